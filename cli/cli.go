@@ -4,6 +4,7 @@ package cli
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"github.com/fstab/h2c/cli/cmdline"
 	"github.com/fstab/h2c/cli/daemon"
@@ -11,10 +12,12 @@ import (
 	"github.com/fstab/h2c/cli/util"
 	"github.com/fstab/h2c/cli/wiretap"
 	"github.com/fstab/h2c/http2client"
+	"github.com/fstab/h2c/http2client/flowcontrol"
 	"github.com/fstab/h2c/http2client/frames"
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 )
@@ -38,9 +41,20 @@ func Run() (string, error) {
 		if err != nil {
 			return "", err
 		}
-		return "", startDaemon(ipc, frameTypesToBeDumped)
+		if cmdline.HPACK_OPTION.IsSet(cmd.Options) && !cmdline.DUMP_OPTION.IsSet(cmd.Options) {
+			return "", fmt.Errorf("Syntax error: Cannot use %v without %v.", cmdline.HPACK_OPTION.Name(), cmdline.DUMP_OPTION.Name())
+		}
+		receiveFlowControl, receiveWindowSize, err := daemon.ParseFlowControlOptions(cmd.Options)
+		if err != nil {
+			return "", err
+		}
+		return "", startDaemon(ipc, frameTypesToBeDumped, cmdline.HPACK_OPTION.IsSet(cmd.Options), cmdline.KEYLOG_OPTION.Get(cmd.Options), receiveFlowControl, receiveWindowSize)
 	case cmdline.WIRETAP_COMMAND.Name():
-		return "", wiretap.Run(cmd.Args[0], cmd.Args[1])
+		tlsOptions, err := daemon.ParseTlsOptions(cmd.Options)
+		if err != nil {
+			return "", err
+		}
+		return "", wiretap.Run(cmd.Args[0], cmd.Args[1], tlsOptions, cmdline.HPACK_OPTION.IsSet(cmd.Options))
 	default:
 		if !ipc.IsListening() {
 			if cmdline.STOP_COMMAND.Name() == cmd.Name {
@@ -86,7 +100,7 @@ func getFrameTypesToBeDumped(options map[string]string) ([]frames.Type, error) {
 			}
 			return excludeToInclude(excluded), nil
 		} else {
-			return frames.AllFrameTypes(), nil
+			return allFrameTypesIncludingUnknown(), nil
 		}
 	}
 }
@@ -106,7 +120,7 @@ func parseListOfFrameTypes(list string) ([]frames.Type, error) {
 // Turns the 'h2c start --dump --exclude ...' option into the equivalent '--include ...' option.
 func excludeToInclude(excluded []frames.Type) []frames.Type {
 	included := make([]frames.Type, 0)
-	for _, t := range frames.AllFrameTypes() {
+	for _, t := range allFrameTypesIncludingUnknown() {
 		if !util.SliceContainsFrameType(excluded, t) {
 			included = append(included, t)
 		}
@@ -117,11 +131,53 @@ func excludeToInclude(excluded []frames.Type) []frames.Type {
 	return included
 }
 
+// All 256 possible frame types, including types that are not implemented in h2c.
+// Frames of unknown types are dumped as hex.
+func allFrameTypesIncludingUnknown() []frames.Type {
+	result := make([]frames.Type, 0, 256)
+	for i := 0; i < 256; i++ {
+		result = append(result, frames.Type(i))
+	}
+	return result
+}
+
 // There are two ways of specifying payload data for PUT and POST: The --file option and the --data option.
 // We simplify this here: If --file is used, we read the file and replace the command line option with --data.
 // This is a bit of a hack, but that way we don't need to read the file later.
+//
+// Other files are read by the h2c process, which may run in another directory,
+// so relative paths are replaced with absolute paths.
 func applySpecialConventions(cmd *rpc.Command) (*rpc.Command, error) {
 	var err error
+	if cmd.Name == cmdline.START_COMMAND.Name() || cmd.Name == cmdline.CONNECT_COMMAND.Name() || cmd.Name == cmdline.WIRETAP_COMMAND.Name() {
+		// Like browsers and curl, honor the SSLKEYLOGFILE environment variable if --keylog is not given.
+		if !cmdline.KEYLOG_OPTION.IsSet(cmd.Options) && os.Getenv("SSLKEYLOGFILE") != "" {
+			cmdline.KEYLOG_OPTION.Set(os.Getenv("SSLKEYLOGFILE"), cmd.Options)
+		}
+		err = makePathAbsolute(cmdline.KEYLOG_OPTION.Name(), cmd.Options)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cmd.Name == cmdline.CONNECT_COMMAND.Name() {
+		// The environment of the connect command is used, because the h2c process may have been started in another environment.
+		// Like curl, HTTPS_PROXY is only used for https connections.
+		if cmdline.PROXY_OPTION.IsSet(cmd.Options) && cmdline.UNIX_SOCKET_OPTION.IsSet(cmd.Options) {
+			return nil, fmt.Errorf("Syntax error: %v cannot be used together with %v.", cmdline.PROXY_OPTION.Name(), cmdline.UNIX_SOCKET_OPTION.Name())
+		}
+		if !cmdline.PROXY_OPTION.IsSet(cmd.Options) && !cmdline.UNIX_SOCKET_OPTION.IsSet(cmd.Options) && !strings.HasPrefix(cmd.Args[0], "http://") && getenv("HTTPS_PROXY") != "" {
+			cmdline.PROXY_OPTION.Set(getenv("HTTPS_PROXY"), cmd.Options)
+		}
+		if !cmdline.NO_PROXY_OPTION.IsSet(cmd.Options) && getenv("NO_PROXY") != "" {
+			cmdline.NO_PROXY_OPTION.Set(getenv("NO_PROXY"), cmd.Options)
+		}
+		for _, option := range []string{cmdline.CACERT_OPTION.Name(), cmdline.CERT_OPTION.Name(), cmdline.KEY_OPTION.Name(), cmdline.UNIX_SOCKET_OPTION.Name()} {
+			err = makePathAbsolute(option, cmd.Options)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
 	if cmd.Name == cmdline.POST_COMMAND.Name() || cmd.Name == cmdline.PUT_COMMAND.Name() {
 		if cmdline.DATA_OPTION.IsSet(cmd.Options) && cmdline.FILE_OPTION.IsSet(cmd.Options) {
 			return nil, fmt.Errorf("Syntax error: --data and --file cannot be used together.")
@@ -135,6 +191,28 @@ func applySpecialConventions(cmd *rpc.Command) (*rpc.Command, error) {
 	return cmd, nil
 }
 
+// getenv returns the value of the environment variable. The lower case name takes precedence, as with curl.
+func getenv(name string) string {
+	if value := os.Getenv(strings.ToLower(name)); value != "" {
+		return value
+	}
+	return os.Getenv(name)
+}
+
+// The option name is the key in the options map.
+func makePathAbsolute(optionName string, options map[string]string) error {
+	path, isSet := options[optionName]
+	if !isSet {
+		return nil
+	}
+	absolutePath, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("%v: Invalid path: %v", path, err.Error())
+	}
+	options[optionName] = absolutePath
+	return nil
+}
+
 func mapFile2Data(cmd *rpc.Command) (*rpc.Command, error) {
 	var (
 		filename string
@@ -158,7 +236,7 @@ func mapFile2Data(cmd *rpc.Command) (*rpc.Command, error) {
 	return cmd, nil
 }
 
-func startDaemon(ipc rpc.IpcManager, frameTypesToBeDumped []frames.Type) error {
+func startDaemon(ipc rpc.IpcManager, frameTypesToBeDumped []frames.Type, dumpHpack bool, keyLogFile string, receiveFlowControl flowcontrol.Policy, receiveWindowSize uint32) error {
 	if ipc.IsListening() {
 		return socketInUseError(ipc)
 	}
@@ -166,14 +244,14 @@ func startDaemon(ipc rpc.IpcManager, frameTypesToBeDumped []frames.Type) error {
 	if err != nil {
 		return err
 	}
-	return daemon.Run(sock, frameTypesToBeDumped)
+	return daemon.Run(sock, frameTypesToBeDumped, dumpHpack, keyLogFile, receiveFlowControl, receiveWindowSize)
 }
 
 func socketInUseError(ipc rpc.IpcManager) error {
 	if pidCommandSuccessful(ipc) {
 		return fmt.Errorf("h2c already running. Run 'h2c stop' to stop the running process.")
 	} else {
-		return fmt.Errorf(ipc.InUseErrorMessage())
+		return errors.New(ipc.InUseErrorMessage())
 	}
 }
 
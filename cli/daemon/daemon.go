@@ -8,7 +8,9 @@ import (
 	"github.com/fstab/h2c/cli/rpc"
 	"github.com/fstab/h2c/cli/util"
 	"github.com/fstab/h2c/http2client"
+	"github.com/fstab/h2c/http2client/flowcontrol"
 	"github.com/fstab/h2c/http2client/frames"
+	"github.com/fstab/h2c/http2client/tlsconfig"
 	"io"
 	"net"
 	"os"
@@ -27,20 +29,28 @@ import (
 //
 // frameTypesToBeDumped is a list of frame types that will be dumped to the console.
 // If it is nil, no frame will be dumped.
-// If it is frame.AllFrameTypes(), all frames will be dumped.
-func Run(sock net.Listener, frameTypesToBeDumped []frames.Type) error {
+// Frames of types that are not implemented in h2c are dumped if their type is in the list.
+// If dumpHpack is true, dumped header blocks show how each header field was HPACK encoded.
+// If keyLogFile is not empty, it is used for TLS key logging unless the connect command specifies another file.
+// receiveFlowControl and receiveWindowSize are used unless the connect command specifies other values.
+func Run(sock net.Listener, frameTypesToBeDumped []frames.Type, dumpHpack bool, keyLogFile string, receiveFlowControl flowcontrol.Policy, receiveWindowSize uint32) error {
 	var conn net.Conn
 	var err error
 	var h2c = http2client.New()
+	h2c.SetKeyLogFile(keyLogFile)
+	h2c.SetReceiveFlowControl(receiveFlowControl, receiveWindowSize)
 	if frameTypesToBeDumped != nil && len(frameTypesToBeDumped) > 0 {
 		h2c.AddFilterForIncomingFrames(makeFrameFilter(DumpIncoming, frameTypesToBeDumped))
 		h2c.AddFilterForOutgoingFrames(makeFrameFilter(DumpOutgoing, frameTypesToBeDumped))
+		if dumpHpack {
+			h2c.EnableHpackInfo()
+		}
 	}
-	stopOnSigterm(sock)
+	stopOnSigterm(h2c, sock)
 	for {
 		if conn, err = sock.Accept(); err != nil {
+			close(sock)
 			return fmt.Errorf("Error while waiting for commands: %v", err.Error())
-			stop(sock)
 		}
 		go executeCommandAndCloseConnection(h2c, conn, sock)
 	}
@@ -61,17 +71,19 @@ func close(sock io.Closer) {
 	}
 }
 
-func stop(sock net.Listener) {
+// stop sends GOAWAY to the server before the h2c process terminates.
+func stop(h2c *http2client.Http2Client, sock net.Listener) {
+	h2c.Disconnect()
 	close(sock)
 	os.Exit(0)
 }
 
-func stopOnSigterm(sock net.Listener) {
+func stopOnSigterm(h2c *http2client.Http2Client, sock net.Listener) {
 	sigc := make(chan os.Signal, 1)
 	signal.Notify(sigc, os.Interrupt)
 	go func(c chan os.Signal) {
 		<-c // Wait for a SIGINT
-		stop(sock)
+		stop(h2c, sock)
 	}(sigc)
 }
 
@@ -91,12 +103,16 @@ func execute(h2c *http2client.Http2Client, cmd *rpc.Command) (string, error) {
 		return executePost(h2c, cmd)
 	case cmdline.PING_COMMAND.Name():
 		return executePing(h2c, cmd)
+	case cmdline.WINDOW_COMMAND.Name():
+		return executeWindow(h2c, cmd)
 	case cmdline.PUSH_LIST_COMMAND.Name():
 		return executePushList(h2c, cmd)
 	case cmdline.STREAM_INFO_COMMAND.Name():
 		return executeStreamInfo(h2c, cmd)
+	case cmdline.CONN_INFO_COMMAND.Name():
+		return h2c.ConnectionInfo()
 	case cmdline.SET_COMMAND.Name():
-		return h2c.SetHeader(cmd.Args[0], cmd.Args[1])
+		return h2c.SetHeader(cmd.Args[0], cmd.Args[1], cmdline.SENSITIVE_OPTION.IsSet(cmd.Options))
 	case cmdline.UNSET_COMMAND.Name():
 		return h2c.UnsetHeader(cmd.Args)
 	default:
@@ -109,10 +125,85 @@ func executeConnect(h2c *http2client.Http2Client, cmd *rpc.Command) (string, err
 	if err != nil {
 		return "", err
 	}
-	return h2c.Connect(scheme, host, port)
+	options := &http2client.ConnectOptions{}
+	if cmdline.MAX_FRAME_SIZE_OPTION.IsSet(cmd.Options) {
+		maxFrameSize, err := strconv.ParseUint(cmdline.MAX_FRAME_SIZE_OPTION.Get(cmd.Options), 10, 32)
+		if err != nil || maxFrameSize < 2<<13 || maxFrameSize > 2<<23-1 {
+			return "", fmt.Errorf("%v: invalid max frame size. Must be between 16384 and 16777215.", cmdline.MAX_FRAME_SIZE_OPTION.Get(cmd.Options))
+		}
+		options.MaxFrameSize = uint32(maxFrameSize)
+	}
+	if cmdline.HEADER_TABLE_SIZE_OPTION.IsSet(cmd.Options) {
+		headerTableSize, err := strconv.ParseUint(cmdline.HEADER_TABLE_SIZE_OPTION.Get(cmd.Options), 10, 32)
+		if err != nil {
+			return "", fmt.Errorf("%v: invalid header table size.", cmdline.HEADER_TABLE_SIZE_OPTION.Get(cmd.Options))
+		}
+		size := uint32(headerTableSize)
+		options.HeaderTableSize = &size
+	}
+	options.DisableHuffman = cmdline.NO_HUFFMAN_OPTION.IsSet(cmd.Options)
+	options.Upgrade = cmdline.UPGRADE_OPTION.IsSet(cmd.Options)
+	options.Proxy = cmdline.PROXY_OPTION.Get(cmd.Options)
+	options.NoProxy = cmdline.NO_PROXY_OPTION.Get(cmd.Options)
+	options.UnixSocket = cmdline.UNIX_SOCKET_OPTION.Get(cmd.Options)
+	options.Tls, err = ParseTlsOptions(cmd.Options)
+	if err != nil {
+		return "", err
+	}
+	options.ReceiveFlowControl, options.ReceiveWindowSize, err = ParseFlowControlOptions(cmd.Options)
+	if err != nil {
+		return "", err
+	}
+	return h2c.Connect(scheme, host, port, options)
+}
+
+// ParseTlsOptions reads the options controlling the verification of the server certificate and the client certificate.
+// It is used for the connect command and for the wiretap command.
+func ParseTlsOptions(options map[string]string) (*tlsconfig.Options, error) {
+	result := &tlsconfig.Options{
+		Insecure:   cmdline.INSECURE_OPTION.IsSet(options),
+		CaCertFile: cmdline.CACERT_OPTION.Get(options),
+		ServerName: cmdline.SERVERNAME_OPTION.Get(options),
+		CertFile:   cmdline.CERT_OPTION.Get(options),
+		KeyFile:    cmdline.KEY_OPTION.Get(options),
+		KeyLogFile: cmdline.KEYLOG_OPTION.Get(options),
+	}
+	if result.KeyFile != "" && result.CertFile == "" {
+		return nil, fmt.Errorf("Syntax error: --key requires --cert.")
+	}
+	if cmdline.PIN_OPTION.IsSet(options) {
+		pins, err := tlsconfig.ParsePins(cmdline.PIN_OPTION.Get(options))
+		if err != nil {
+			return nil, err
+		}
+		result.Pins = pins
+	}
+	return result, nil
+}
+
+// ParseFlowControlOptions reads --flow-control and --window. It is used for the start command and for the connect command.
+// If the options are not set, the results are nil and 0.
+func ParseFlowControlOptions(options map[string]string) (flowcontrol.Policy, uint32, error) {
+	var policy flowcontrol.Policy
+	var err error
+	if cmdline.FLOW_CONTROL_OPTION.IsSet(options) {
+		policy, err = flowcontrol.Parse(cmdline.FLOW_CONTROL_OPTION.Get(options))
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	windowSize := uint64(0)
+	if cmdline.WINDOW_OPTION.IsSet(options) {
+		windowSize, err = strconv.ParseUint(cmdline.WINDOW_OPTION.Get(options), 10, 32)
+		if err != nil || windowSize < flowcontrol.DEFAULT_WINDOW_SIZE || windowSize > flowcontrol.MAX_WINDOW_SIZE {
+			return nil, 0, fmt.Errorf("%v: invalid window size. Must be between %v and %v.", cmdline.WINDOW_OPTION.Get(options), flowcontrol.DEFAULT_WINDOW_SIZE, flowcontrol.MAX_WINDOW_SIZE)
+		}
+	}
+	return policy, uint32(windowSize), nil
 }
 
 // "https://localhost:8443" -> "https", "localhost", 8443, nil
+// "http://localhost" -> "http", "localhost", 80, nil
 func parseSchemeHostPort(arg string) (string, string, int, error) {
 	var (
 		scheme string
@@ -144,6 +235,9 @@ func parseSchemeHostPort(arg string) (string, string, int, error) {
 	} else {
 		host = remaining
 		port = 443
+		if scheme == "http" {
+			port = 80
+		}
 		if strings.Contains(host, "/") || strings.Contains(host, "&") || strings.Contains(host, "#") {
 			return "", "", 0, fmt.Errorf("%v: Invalid hostname", arg)
 		}
@@ -167,7 +261,44 @@ func executeGet(h2c *http2client.Http2Client, cmd *rpc.Command) (string, error)
 	} else {
 		timeout = 10
 	}
-	return h2c.Get(cmd.Args[0], includeHeaders, timeout)
+	options, err := parseRequestOptions(cmd)
+	if err != nil {
+		return "", err
+	}
+	return h2c.Get(cmd.Args[0], includeHeaders, timeout, options)
+}
+
+func parseRequestOptions(cmd *rpc.Command) (*http2client.RequestOptions, error) {
+	options := &http2client.RequestOptions{}
+	options.RetryIfNotProcessed = cmdline.RETRY_OPTION.IsSet(cmd.Options)
+	if cmdline.PAD_OPTION.IsSet(cmd.Options) {
+		padLength, err := strconv.Atoi(cmdline.PAD_OPTION.Get(cmd.Options))
+		if err != nil || padLength < 0 || padLength > 255 {
+			return nil, fmt.Errorf("%v: invalid pad length. Must be between 0 and 255.", cmdline.PAD_OPTION.Get(cmd.Options))
+		}
+		options.Padded = true
+		options.PadLength = uint8(padLength)
+	}
+	if cmdline.DEPENDS_ON_OPTION.IsSet(cmd.Options) || cmdline.WEIGHT_OPTION.IsSet(cmd.Options) || cmdline.EXCLUSIVE_OPTION.IsSet(cmd.Options) {
+		options.Priority = true
+		options.Weight = 15 // default weight 16, see RFC 7540 section 5.3.5
+	}
+	if cmdline.DEPENDS_ON_OPTION.IsSet(cmd.Options) {
+		streamDependency, err := strconv.ParseUint(cmdline.DEPENDS_ON_OPTION.Get(cmd.Options), 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("%v: invalid stream id.", cmdline.DEPENDS_ON_OPTION.Get(cmd.Options))
+		}
+		options.StreamDependency = uint32(streamDependency)
+	}
+	if cmdline.WEIGHT_OPTION.IsSet(cmd.Options) {
+		weight, err := strconv.Atoi(cmdline.WEIGHT_OPTION.Get(cmd.Options))
+		if err != nil || weight < 1 || weight > 256 {
+			return nil, fmt.Errorf("%v: invalid weight. Must be between 1 and 256.", cmdline.WEIGHT_OPTION.Get(cmd.Options))
+		}
+		options.Weight = uint8(weight - 1)
+	}
+	options.Exclusive = cmdline.EXCLUSIVE_OPTION.IsSet(cmd.Options)
+	return options, nil
 }
 
 func executePushList(h2c *http2client.Http2Client, cmd *rpc.Command) (string, error) {
@@ -195,6 +326,24 @@ func executePing(h2c *http2client.Http2Client, cmd *rpc.Command) (string, error)
 	}
 }
 
+func executeWindow(h2c *http2client.Http2Client, cmd *rpc.Command) (string, error) {
+	if len(cmd.Args) == 0 {
+		return h2c.Window()
+	}
+	increment, err := strconv.ParseUint(cmd.Args[1], 10, 31)
+	if err != nil {
+		return "", fmt.Errorf("%v: invalid increment. Must be between 0 and 2147483647.", cmd.Args[1])
+	}
+	streamId := uint64(0)
+	if len(cmd.Args) == 3 {
+		streamId, err = strconv.ParseUint(cmd.Args[2], 10, 31)
+		if err != nil {
+			return "", fmt.Errorf("%v: invalid stream id.", cmd.Args[2])
+		}
+	}
+	return h2c.WindowUpdate(uint32(streamId), uint32(increment))
+}
+
 func parseTimeInterval(intervalString string) (time.Duration, error) {
 	var (
 		interval int
@@ -228,7 +377,7 @@ func executePost(h2c *http2client.Http2Client, cmd *rpc.Command) (string, error)
 	return executePutOrPost(h2c, cmd, h2c.Post)
 }
 
-func executePutOrPost(h2c *http2client.Http2Client, cmd *rpc.Command, putOrPost func(path string, data []byte, includeHeaders bool, timeoutInSeconds int) (string, error)) (string, error) {
+func executePutOrPost(h2c *http2client.Http2Client, cmd *rpc.Command, putOrPost func(path string, data []byte, includeHeaders bool, timeoutInSeconds int, options *http2client.RequestOptions) (string, error)) (string, error) {
 	includeHeaders := cmdline.INCLUDE_HEADERS_OPTION.IsSet(cmd.Options)
 	var timeout int
 	var err error
@@ -244,7 +393,11 @@ func executePutOrPost(h2c *http2client.Http2Client, cmd *rpc.Command, putOrPost
 	if cmdline.DATA_OPTION.IsSet(cmd.Options) {
 		data = []byte(cmdline.DATA_OPTION.Get(cmd.Options))
 	}
-	return putOrPost(cmd.Args[0], data, includeHeaders, timeout)
+	options, err := parseRequestOptions(cmd)
+	if err != nil {
+		return "", err
+	}
+	return putOrPost(cmd.Args[0], data, includeHeaders, timeout, options)
 }
 
 func executeCommandAndCloseConnection(h2c *http2client.Http2Client, conn net.Conn, sock net.Listener) {
@@ -258,7 +411,7 @@ func executeCommandAndCloseConnection(h2c *http2client.Http2Client, conn net.Con
 	}
 	if cmd.Name == cmdline.STOP_COMMAND.Name() {
 		writeResult(conn, "", nil)
-		stop(sock)
+		stop(h2c, sock)
 	} else {
 		msg, err := execute(h2c, cmd)
 		writeResult(conn, msg, err)
@@ -279,5 +432,5 @@ func writeResult(conn io.Writer, msg string, err error) {
 }
 
 func handleCommunicationError(format string, a ...interface{}) {
-	fmt.Fprintf(os.Stderr, "Error communicating with the h2c command line: %v", fmt.Sprintf(format, a))
+	fmt.Fprintf(os.Stderr, "Error communicating with the h2c command line: %v", fmt.Sprintf(format, a...))
 }
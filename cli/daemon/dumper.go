@@ -1,9 +1,12 @@
 package daemon
 
 import (
+	"encoding/hex"
 	"fmt"
 	"github.com/fatih/color"
 	"github.com/fstab/h2c/http2client/frames"
+	"golang.org/x/net/http2/hpack"
+	"strings"
 )
 
 var (
@@ -13,6 +16,7 @@ var (
 	flagColor      = color.New(color.FgGreen)
 	keyColor       = color.New(color.FgBlue)
 	valueColor     = color.New()
+	hpackColor     = color.New(color.FgYellow)
 )
 
 func DumpIncoming(frame frames.Frame) {
@@ -31,18 +35,14 @@ func dump(prefix string, frame frames.Frame) {
 		streamIdColor.Printf("(%v)\n", f.StreamId)
 		dumpEndStream(f.EndStream)
 		dumpEndHeaders(f.EndHeaders)
-		if len(f.Headers) == 0 {
-			keyColor.Printf("    {empty}\n")
-		} else {
-			for _, header := range f.Headers {
-				keyColor.Printf("    %v:", header.Name)
-				valueColor.Printf(" %v\n", header.Value)
-			}
-		}
+		dumpPadded(f.Padded, f.PadLength)
+		dumpPriority(f)
+		dumpHeaderBlock(f.Headers, f.HpackInfo)
 	case *frames.DataFrame:
 		frameTypeColor.Printf("%v", frame.Type())
 		streamIdColor.Printf("(%v)\n", f.StreamId)
 		dumpEndStream(f.EndStream)
+		dumpPadded(f.Padded, f.PadLength)
 		keyColor.Printf("    {%v bytes}\n", len(f.Data))
 	case *frames.PriorityFrame:
 		frameTypeColor.Printf("%v", frame.Type())
@@ -68,16 +68,10 @@ func dump(prefix string, frame frames.Frame) {
 		frameTypeColor.Printf("%v", frame.Type())
 		streamIdColor.Printf("(%v)\n", f.StreamId)
 		dumpEndHeaders(f.EndHeaders)
+		dumpPadded(f.Padded, f.PadLength)
 		keyColor.Printf("    Promised Stream Id:")
 		valueColor.Printf(" %v\n", f.PromisedStreamId)
-		if len(f.Headers) == 0 {
-			keyColor.Printf("    {empty}\n")
-		} else {
-			for _, header := range f.Headers {
-				keyColor.Printf("    %v:", header.Name)
-				valueColor.Printf(" %v\n", header.Value)
-			}
-		}
+		dumpHeaderBlock(f.Headers, f.HpackInfo)
 	case *frames.RstStreamFrame:
 		frameTypeColor.Printf("%v", frame.Type())
 		streamIdColor.Printf("(%v)\n", f.StreamId)
@@ -96,17 +90,121 @@ func dump(prefix string, frame frames.Frame) {
 		valueColor.Printf(" %v\n", f.LastStreamId)
 		keyColor.Printf("    Error code:")
 		valueColor.Printf(" %v\n", f.ErrorCode.String())
+		if len(f.AdditionalDebugData) > 0 {
+			keyColor.Printf("    Additional debug data:")
+			valueColor.Printf(" %q\n", f.AdditionalDebugData)
+		}
+	case *frames.ContinuationFrame:
+		frameTypeColor.Printf("%v", frame.Type())
+		streamIdColor.Printf("(%v)\n", f.StreamId)
+		dumpEndHeaders(f.EndHeaders)
+		dumpHeaderBlock(f.Headers, f.HpackInfo)
 	case *frames.WindowUpdateFrame:
 		frameTypeColor.Printf("%v", frame.Type())
 		streamIdColor.Printf("(%v)\n", f.StreamId)
 		keyColor.Printf("    Window size increment:")
 		valueColor.Printf(" %v\n", f.WindowSizeIncrement)
+	case *frames.AltSvcFrame:
+		frameTypeColor.Printf("%v", frame.Type())
+		streamIdColor.Printf("(%v)\n", f.StreamId)
+		keyColor.Printf("    Origin:")
+		valueColor.Printf(" %v\n", f.Origin)
+		keyColor.Printf("    Alt-Svc:")
+		valueColor.Printf(" %v\n", f.Value)
+	case *frames.OriginFrame:
+		frameTypeColor.Printf("%v", frame.Type())
+		streamIdColor.Printf("(%v)\n", f.StreamId)
+		if len(f.Origins) == 0 {
+			keyColor.Printf("    {empty}\n")
+		} else {
+			for _, origin := range f.Origins {
+				keyColor.Printf("    Origin:")
+				valueColor.Printf(" %v\n", origin)
+			}
+		}
+	case *frames.UnknownFrame:
+		frameTypeColor.Printf("%v", frame.Type())
+		streamIdColor.Printf("(%v)\n", f.StreamId)
+		keyColor.Printf("    Flags:")
+		valueColor.Printf(" 0x%02x\n", f.Flags)
+		dumpPayload(f.Payload)
 	default:
 		frameTypeColor.Printf("UNKNOWN (NOT IMPLEMENTED) FRAME TYPE %v\n", frame.Type())
 	}
 	fmt.Println()
 }
 
+// If the frame carries HpackInfo, the representation of each header field is shown.
+func dumpHeaderBlock(headers []hpack.HeaderField, info *frames.HpackInfo) {
+	if info == nil {
+		dumpHeaders(headers)
+		return
+	}
+	if len(info.Fields) == 0 {
+		keyColor.Printf("    {empty}\n")
+	}
+	for _, field := range info.Fields {
+		if field.Representation == frames.HPACK_TABLE_SIZE_UPDATE {
+			hpackColor.Printf("    {%v: %v bytes}\n", field.Representation, field.TableSize)
+		} else {
+			keyColor.Printf("    %v:", field.Header.Name)
+			valueColor.Printf(" %v", field.Header.Value)
+			hpackColor.Printf(" {%v}\n", describeHpackField(field))
+		}
+		for _, evicted := range field.Evicted {
+			hpackColor.Printf("        {evicted %v: %v}\n", evicted.Name, evicted.Value)
+		}
+	}
+	hpackColor.Printf("    {header block: %v bytes encoded, %v bytes decoded}\n", info.EncodedLength, info.DecodedLength)
+}
+
+func describeHpackField(field *frames.HpackField) string {
+	result := []string{field.Representation.String()}
+	switch {
+	case field.Index == 0:
+		result = append(result, "new name")
+	case field.Representation == frames.HPACK_INDEXED:
+		result = append(result, fmt.Sprintf("%v index %v", tableName(field), field.Index))
+	default:
+		result = append(result, fmt.Sprintf("name from %v index %v", tableName(field), field.Index))
+	}
+	if field.HuffmanName {
+		result = append(result, "Huffman name")
+	}
+	if field.HuffmanValue {
+		result = append(result, "Huffman value")
+	}
+	return strings.Join(append(result, fmt.Sprintf("%v bytes", field.EncodedLength)), ", ")
+}
+
+func tableName(field *frames.HpackField) string {
+	if field.IsStaticTableIndex() {
+		return "static table"
+	}
+	return "dynamic table"
+}
+
+func dumpHeaders(headers []hpack.HeaderField) {
+	if len(headers) == 0 {
+		keyColor.Printf("    {empty}\n")
+	} else {
+		for _, header := range headers {
+			keyColor.Printf("    %v:", header.Name)
+			valueColor.Printf(" %v\n", header.Value)
+		}
+	}
+}
+
+func dumpPayload(payload []byte) {
+	if len(payload) == 0 {
+		keyColor.Printf("    {empty}\n")
+	} else {
+		for _, line := range strings.Split(strings.TrimSuffix(hex.Dump(payload), "\n"), "\n") {
+			valueColor.Printf("    %v\n", line)
+		}
+	}
+}
+
 func dumpFlag(name string, isSet bool) {
 	if isSet {
 		flagColor.Printf("    + %v\n", name)
@@ -125,3 +223,23 @@ func dumpEndHeaders(isSet bool) {
 func dumpAck(isSet bool) {
 	dumpFlag("ACK", isSet)
 }
+
+func dumpPriority(f *frames.HeadersFrame) {
+	dumpFlag("PRIORITY", f.Priority)
+	if f.Priority {
+		keyColor.Printf("    Stream dependency:")
+		valueColor.Printf(" %v\n", f.StreamDependency)
+		keyColor.Printf("    Exclusive:")
+		valueColor.Printf(" %v\n", f.Exclusive)
+		keyColor.Printf("    Weight:")
+		valueColor.Printf(" %v\n", int(f.Weight)+1)
+	}
+}
+
+func dumpPadded(isSet bool, padLength uint8) {
+	dumpFlag("PADDED", isSet)
+	if isSet {
+		keyColor.Printf("    Pad length:")
+		valueColor.Printf(" %v\n", padLength)
+	}
+}
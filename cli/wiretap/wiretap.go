@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/fstab/h2c/cli/daemon"
 	"github.com/fstab/h2c/http2client/frames"
+	"github.com/fstab/h2c/http2client/tlsconfig"
 	"golang.org/x/net/http2/hpack"
 	"io"
 	"net"
@@ -68,7 +69,10 @@ zuaJapKwoKydbiLGlhvpcBtsE5ahRo3A62ckaeVi4JX7dXcpqAtM9A==
 
 const CLIENT_PREFACE = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
 
-func Run(local string, remote string) error {
+// tlsOptions control how the remote server's certificate is verified.
+// tlsOptions.KeyLogFile is used for both the connection with the client and the connection with the server.
+// If dumpHpack is true, dumped header blocks show how each header field was HPACK encoded by the sender.
+func Run(local string, remote string, tlsOptions *tlsconfig.Options, dumpHpack bool) error {
 	if !strings.Contains(remote, ":") {
 		remote = remote + ":443"
 	}
@@ -85,7 +89,7 @@ func Run(local string, remote string) error {
 			return err
 		}
 		go func() {
-			err := handleConnection(conn, local, remote, dumpIncoming, dumpOutgoing)
+			err := handleConnection(conn, local, remote, tlsOptions, dumpHpack, dumpIncoming, dumpOutgoing)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "Error while handling connection: %v\n", err.Error())
 			}
@@ -93,8 +97,12 @@ func Run(local string, remote string) error {
 	}
 }
 
-func handleConnection(conn net.Conn, local, remote string, dumpIncoming, dumpOutgoing chan frames.Frame) error {
-	clientConn, err := negotiateH2Protocol(conn)
+func handleConnection(conn net.Conn, local, remote string, tlsOptions *tlsconfig.Options, dumpHpack bool, dumpIncoming, dumpOutgoing chan frames.Frame) error {
+	keyLogFile := ""
+	if tlsOptions != nil {
+		keyLogFile = tlsOptions.KeyLogFile
+	}
+	clientConn, err := negotiateH2Protocol(conn, keyLogFile)
 	if err != nil {
 		return err
 	}
@@ -102,12 +110,15 @@ func handleConnection(conn net.Conn, local, remote string, dumpIncoming, dumpOut
 	if err != nil {
 		return err
 	}
-	serverConn, err := connectToServer(remote)
+	serverConn, err := connectToServer(remote, tlsOptions)
 	if err != nil {
 		return err
 	}
-	go forwardFrames(clientConn, serverConn, remote, dumpOutgoing)
-	go forwardFrames(serverConn, clientConn, local, dumpIncoming)
+	client := newPeer(clientConn, dumpHpack)
+	server := newPeer(serverConn, dumpHpack)
+	server.tlsOptions = tlsOptions
+	go forwardFrames(client, server, remote, dumpOutgoing)
+	go forwardFrames(server, client, local, dumpIncoming)
 	return nil
 }
 
@@ -122,21 +133,57 @@ func dumpFrames(in chan frames.Frame, out chan frames.Frame) {
 	}
 }
 
-func forwardFrames(from net.Conn, to net.Conn, remoteAuthority string, dump chan frames.Frame) {
-	defer from.Close()
-	defer to.Close()
-	encodingContext := frames.NewEncodingContext()
-	decodingContext := frames.NewDecodingContext()
+// peer is one side of the wiretapped connection.
+// Frames from the peer are read with the reader, frames to the peer are written with the writer.
+type peer struct {
+	conn            net.Conn
+	decodingContext *frames.DecodingContext
+	encodingContext *frames.EncodingContext
+	reader          *frames.Reader
+	writer          *frames.Writer
+	tlsOptions      *tlsconfig.Options // Only set for the remote server, used to describe rejected client certificates.
+}
+
+func newPeer(conn net.Conn, hpackInfo bool) *peer {
+	result := &peer{
+		conn:            conn,
+		decodingContext: frames.NewDecodingContext(),
+		encodingContext: frames.NewEncodingContext(),
+	}
+	if hpackInfo {
+		// Only the frames read from the peer are dumped, so there is no need to track our own encoding.
+		result.decodingContext.EnableHpackInfo()
+	}
+	result.reader = frames.NewReader(conn, result.decodingContext)
+	result.writer = frames.NewWriter(conn, result.encodingContext)
+	return result
+}
+
+// forwardFrames reads frames from one peer and writes them to the other.
+// When a peer advertises SETTINGS_MAX_FRAME_SIZE, frames sent to that peer may be larger,
+// so the limits for reading from the other peer and for writing to this peer are changed.
+func forwardFrames(from *peer, to *peer, remoteAuthority string, dump chan frames.Frame) {
+	defer from.conn.Close()
+	defer to.conn.Close()
 	for {
-		frame, err := readNextFrame(from, decodingContext)
+		frame, err := from.reader.ReadFrame()
 		if err != nil {
+			if from.tlsOptions != nil {
+				if certErr := tlsconfig.ClientCertificateError(from.tlsOptions, err, false); certErr != nil {
+					err = certErr
+				}
+			}
 			fmt.Fprintf(os.Stderr, "Error while reading next frame: %v\n", err.Error())
 			fmt.Fprintf(os.Stderr, "Closing connection.\n")
 			return
 		}
+		if settingsFrame, isSettings := frame.(*frames.SettingsFrame); isSettings && frames.SETTINGS_MAX_FRAME_SIZE.IsSet(settingsFrame) {
+			to.decodingContext.SetMaxFrameSize(frames.SETTINGS_MAX_FRAME_SIZE.Get(settingsFrame))
+			from.encodingContext.SetMaxFrameSize(frames.SETTINGS_MAX_FRAME_SIZE.Get(settingsFrame))
+		}
 		fixAuthorityHeader(frame, remoteAuthority)
 		dump <- frame
-		err = writeFrame(to, frame, encodingContext)
+		err = to.writer.WriteFrame(frame)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error while forwarding next frame: %v\n", err.Error())
 			fmt.Fprintf(os.Stderr, "Closing connection.\n")
@@ -182,48 +229,23 @@ func fixAuthorityHeader(frame frames.Frame, remoteAuthority string) {
 	}
 }
 
-// TODO: copy-and-paste from connection
-func readNextFrame(conn net.Conn, context *frames.DecodingContext) (frames.Frame, error) {
-	headerData := make([]byte, 9) // Frame starts with a 9 Bytes header
-	_, err := io.ReadFull(conn, headerData)
-	if err != nil {
-		return nil, err
-	}
-	header := frames.DecodeHeader(headerData)
-	payload := make([]byte, header.Length)
-	_, err = io.ReadFull(conn, payload)
-	if err != nil {
-		return nil, err
-	}
-	decodeFunc := frames.FindDecoder(frames.Type(header.HeaderType))
-	if decodeFunc == nil {
-		return nil, fmt.Errorf("%v: Unknown frame type.", header.HeaderType)
-	}
-	return decodeFunc(header.Flags, header.StreamId, payload, context)
-}
-
-// TODO: copy-and-paste from connection
-func writeFrame(conn net.Conn, frame frames.Frame, context *frames.EncodingContext) error {
-	encodedFrame, err := frame.Encode(context)
-	if err != nil {
-		return fmt.Errorf("Failed to write frame: %v", err.Error())
-	}
-	_, err = conn.Write(encodedFrame)
-	if err != nil {
-		return fmt.Errorf("Failed to write frame: %v", err.Error())
-	}
-	return nil
-}
-
-func negotiateH2Protocol(conn net.Conn) (*tls.Conn, error) {
+// If keyLogFile is not empty, the TLS secrets of the connection with the client are appended to that file.
+func negotiateH2Protocol(conn net.Conn, keyLogFile string) (*tls.Conn, error) {
 	keyPair, err := tls.X509KeyPair([]byte(CERT), []byte(KEY))
 	if err != nil {
 		return nil, err
 	}
-	tlsConn := tls.Server(conn, &tls.Config{
+	config := &tls.Config{
 		Certificates: []tls.Certificate{keyPair},
 		NextProtos:   []string{"h2"},
-	})
+	}
+	if keyLogFile != "" {
+		config.KeyLogWriter, err = tlsconfig.KeyLogWriter(keyLogFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	tlsConn := tls.Server(conn, config)
 	err = tlsConn.Handshake()
 	if err != nil {
 		return nil, err
@@ -248,13 +270,25 @@ func receiveClientPreface(tlsConn *tls.Conn) error {
 	return nil
 }
 
-func connectToServer(hostAndPort string) (*tls.Conn, error) {
-	dialerWithTimeout := &net.Dialer{Timeout: 5 * time.Second}
-	tlsConn, err := tls.DialWithDialer(dialerWithTimeout, "tcp", hostAndPort, &tls.Config{
-		InsecureSkipVerify: true,
-		NextProtos:         []string{"h2"},
+func connectToServer(hostAndPort string, tlsOptions *tlsconfig.Options) (*tls.Conn, error) {
+	host, _, err := net.SplitHostPort(hostAndPort)
+	if err != nil {
+		return nil, fmt.Errorf("%v: Invalid host and port: %v", hostAndPort, err.Error())
+	}
+	config, err := tlsconfig.New(host, []string{"h2"}, tlsOptions)
+	if err != nil {
+		return nil, err
+	}
+	clientCertRequested := false
+	tlsconfig.OnClientCertificateRequest(config, func() {
+		clientCertRequested = true
 	})
+	dialerWithTimeout := &net.Dialer{Timeout: 5 * time.Second}
+	tlsConn, err := tls.DialWithDialer(dialerWithTimeout, "tcp", hostAndPort, config)
 	if err != nil {
+		if certErr := tlsconfig.ClientCertificateError(tlsOptions, err, clientCertRequested); certErr != nil {
+			err = certErr
+		}
 		return nil, fmt.Errorf("Failed to connect to %v: %v", hostAndPort, err.Error())
 	}
 	if tlsConn.ConnectionState().NegotiatedProtocol != "h2" {
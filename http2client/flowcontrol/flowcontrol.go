@@ -0,0 +1,138 @@
+// Package flowcontrol implements policies deciding when the client sends WINDOW_UPDATE frames
+// for received DATA frames, see RFC 7540 section 6.9.
+//
+// The same Policy is used for the connection's receive window and for the receive windows of all streams.
+package flowcontrol
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_WINDOW_SIZE = 2<<15 - 1 // Initial flow-control window size defined in RFC 7540 section 6.9.2.
+	MAX_WINDOW_SIZE     = 2<<30 - 1 // Maximum flow-control window size defined in RFC 7540 section 6.9.1.
+	DEFAULT_THRESHOLD   = 2 << 13   // Size of one frame with the initial SETTINGS_MAX_FRAME_SIZE.
+	MAX_BDP_WINDOW_SIZE = 2 << 23   // Upper limit for the window size found by the BDP policy (16 MiB).
+)
+
+// Policy decides when a WINDOW_UPDATE frame is sent.
+type Policy interface {
+	// WindowUpdate is called after a DATA frame was received. windowSize is the receive window size the client maintains,
+	// remaining is the number of bytes the server may still send. The result is the increment for a WINDOW_UPDATE frame,
+	// 0 means that no WINDOW_UPDATE frame is sent.
+	WindowUpdate(windowSize int64, remaining int64) int64
+	// Name of the policy, like "threshold:16384".
+	String() string
+}
+
+// Tuner is implemented by policies that grow the window size while data is received.
+// The connection sends a PING frame when a DATA frame is received, and calls Tune when the PING is acknowledged.
+type Tuner interface {
+	// Tune returns the new window size. bytesPerRoundTrip is the number of bytes received between sending the PING
+	// and receiving the acknowledgement. The result must not be smaller than windowSize.
+	Tune(windowSize int64, bytesPerRoundTrip int64, rtt time.Duration) int64
+}
+
+type thresholdPolicy struct {
+	threshold int64
+}
+
+// Threshold refills the window when less than threshold bytes remain. This was the only policy in earlier versions of h2c.
+func Threshold(threshold int64) Policy {
+	return &thresholdPolicy{threshold: threshold}
+}
+
+func (p *thresholdPolicy) WindowUpdate(windowSize int64, remaining int64) int64 {
+	if remaining < p.threshold {
+		return windowSize - remaining
+	}
+	return 0
+}
+
+func (p *thresholdPolicy) String() string {
+	return fmt.Sprintf("threshold:%v", p.threshold)
+}
+
+type bdpPolicy struct{}
+
+// Bdp refills the window when half of it is used, and doubles the window size when the bandwidth-delay product
+// measured with PING frames comes close to the window size, like gRPC's BDP estimator.
+func Bdp() Policy {
+	return &bdpPolicy{}
+}
+
+func (p *bdpPolicy) WindowUpdate(windowSize int64, remaining int64) int64 {
+	if remaining < windowSize/2 {
+		return windowSize - remaining
+	}
+	return 0
+}
+
+func (p *bdpPolicy) Tune(windowSize int64, bytesPerRoundTrip int64, rtt time.Duration) int64 {
+	if bytesPerRoundTrip < windowSize*2/3 || windowSize >= MAX_BDP_WINDOW_SIZE {
+		return windowSize
+	}
+	result := 2 * bytesPerRoundTrip
+	if result > MAX_BDP_WINDOW_SIZE {
+		result = MAX_BDP_WINDOW_SIZE
+	}
+	if result < windowSize {
+		result = windowSize
+	}
+	return result
+}
+
+func (p *bdpPolicy) String() string {
+	return "bdp"
+}
+
+type noUpdatePolicy struct {
+	name string
+}
+
+// Never sends no WINDOW_UPDATE frames at all, so that the server stalls when the window is used up.
+func Never() Policy {
+	return &noUpdatePolicy{name: "never"}
+}
+
+// Manual sends no WINDOW_UPDATE frames automatically. They are sent with the 'h2c window' command.
+func Manual() Policy {
+	return &noUpdatePolicy{name: "manual"}
+}
+
+func (p *noUpdatePolicy) WindowUpdate(windowSize int64, remaining int64) int64 {
+	return 0
+}
+
+func (p *noUpdatePolicy) String() string {
+	return p.name
+}
+
+// Parse returns the policy for "threshold", "threshold:<bytes>", "bdp", "never", or "manual".
+func Parse(spec string) (Policy, error) {
+	name, param := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		name, param = spec[:i], spec[i+1:]
+	}
+	switch {
+	case name == "threshold" && param == "":
+		return Threshold(DEFAULT_THRESHOLD), nil
+	case name == "threshold":
+		threshold, err := strconv.ParseUint(param, 10, 31)
+		if err != nil || threshold == 0 {
+			return nil, fmt.Errorf("%v: Invalid threshold. Must be between 1 and %v.", spec, MAX_WINDOW_SIZE)
+		}
+		return Threshold(int64(threshold)), nil
+	case spec == "bdp":
+		return Bdp(), nil
+	case spec == "never":
+		return Never(), nil
+	case spec == "manual":
+		return Manual(), nil
+	default:
+		return nil, fmt.Errorf("%v: Unknown flow control policy. Valid policies are threshold, threshold:<bytes>, bdp, never, and manual.", spec)
+	}
+}
@@ -0,0 +1,63 @@
+package flowcontrol
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	for spec, expected := range map[string]string{
+		"threshold":       "threshold:16384",
+		"threshold:32768": "threshold:32768",
+		"bdp":             "bdp",
+		"never":           "never",
+		"manual":          "manual",
+	} {
+		policy, err := Parse(spec)
+		if err != nil || policy.String() != expected {
+			t.Errorf("Expected Parse(%v) to return %v, but got %v (error: %v).", spec, expected, policy, err)
+		}
+	}
+	for _, spec := range []string{"", "threshold:0", "threshold:abc", "bdp:100", "always"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Expected error for Parse(%v).", spec)
+		}
+	}
+}
+
+func TestThreshold(t *testing.T) {
+	policy := Threshold(1000)
+	if policy.WindowUpdate(5000, 1000) != 0 {
+		t.Error("Expected no WINDOW_UPDATE as long as the threshold is not reached.")
+	}
+	if policy.WindowUpdate(5000, 999) != 4001 {
+		t.Errorf("Expected the window to be refilled, but got increment %v.", policy.WindowUpdate(5000, 999))
+	}
+}
+
+func TestNoUpdate(t *testing.T) {
+	for _, policy := range []Policy{Never(), Manual()} {
+		if policy.WindowUpdate(DEFAULT_WINDOW_SIZE, -100) != 0 {
+			t.Errorf("Expected no WINDOW_UPDATE with policy %v.", policy)
+		}
+	}
+}
+
+func TestBdpTune(t *testing.T) {
+	tuner := Bdp().(Tuner)
+	for _, test := range []struct {
+		windowSize        int64
+		bytesPerRoundTrip int64
+		expected          int64
+	}{
+		{65535, 1000, 65535},   // The window is not the bottleneck.
+		{65535, 60000, 120000}, // Close to the window size, so the window grows.
+		{MAX_BDP_WINDOW_SIZE / 2, MAX_BDP_WINDOW_SIZE / 2, MAX_BDP_WINDOW_SIZE}, // Limited to MAX_BDP_WINDOW_SIZE.
+		{MAX_BDP_WINDOW_SIZE, MAX_BDP_WINDOW_SIZE, MAX_BDP_WINDOW_SIZE},
+	} {
+		result := tuner.Tune(test.windowSize, test.bytesPerRoundTrip, 10*time.Millisecond)
+		if result != test.expected {
+			t.Errorf("Expected Tune(%v, %v) to return %v, but got %v.", test.windowSize, test.bytesPerRoundTrip, test.expected, result)
+		}
+	}
+}
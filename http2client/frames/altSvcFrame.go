@@ -0,0 +1,66 @@
+package frames
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// AltSvcFrame advertises an alternative service, see RFC 7838 section 4.
+//
+// On stream 0, Origin contains the origin the alternative service applies to.
+// On other streams, Origin is empty and the alternative service applies to the origin of the stream.
+type AltSvcFrame struct {
+	StreamId uint32
+	Origin   string
+	Value    string // Alt-Svc field value, like h2=":8443"; ma=3600
+}
+
+func NewAltSvcFrame(streamId uint32, origin string, value string) *AltSvcFrame {
+	return &AltSvcFrame{
+		StreamId: streamId,
+		Origin:   origin,
+		Value:    value,
+	}
+}
+
+func DecodeAltSvcFrame(flags byte, streamId uint32, payload []byte, context *DecodingContext) (Frame, error) {
+	if len(payload) < 2 {
+		return nil, newConnectionError(FRAME_SIZE_ERROR, "Received %v frame of length %v.", ALTSVC_TYPE, len(payload))
+	}
+	originLen := int(binary.BigEndian.Uint16(payload[0:2]))
+	if len(payload) < 2+originLen {
+		return nil, newConnectionError(FRAME_SIZE_ERROR, "Received %v frame with origin length %v, but frame length is only %v.", ALTSVC_TYPE, originLen, len(payload))
+	}
+	origin := string(payload[2 : 2+originLen])
+	value := string(payload[2+originLen:])
+	return NewAltSvcFrame(streamId, origin, value), nil
+}
+
+// IsValid returns false if the frame must be ignored, see RFC 7838 section 4.
+func (f *AltSvcFrame) IsValid() bool {
+	if f.StreamId == 0 {
+		return len(f.Origin) > 0
+	} else {
+		return len(f.Origin) == 0
+	}
+}
+
+func (f *AltSvcFrame) Type() Type {
+	return ALTSVC_TYPE
+}
+
+func (f *AltSvcFrame) Encode(context *EncodingContext) ([]byte, error) {
+	originLen := make([]byte, 2)
+	binary.BigEndian.PutUint16(originLen, uint16(len(f.Origin)))
+	length := uint32(len(originLen) + len(f.Origin) + len(f.Value))
+	var result bytes.Buffer
+	result.Write(encodeHeader(f.Type(), f.StreamId, length, []Flag{}))
+	result.Write(originLen)
+	result.WriteString(f.Origin)
+	result.WriteString(f.Value)
+	return result.Bytes(), nil
+}
+
+func (f *AltSvcFrame) GetStreamId() uint32 {
+	return f.StreamId
+}
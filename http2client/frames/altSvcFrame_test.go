@@ -0,0 +1,57 @@
+package frames
+
+import (
+	"reflect"
+	"testing"
+)
+
+func encodeAndDecode(t *testing.T, frame Frame) Frame {
+	data, err := frame.Encode(NewEncodingContext())
+	if err != nil {
+		t.Fatal("Encoding error:", err.Error())
+	}
+	frameHeader := DecodeHeader(data[0:9])
+	if int(frameHeader.Length) != len(data)-9 {
+		t.Fatalf("Frame header length %v, but payload has %v bytes.", frameHeader.Length, len(data)-9)
+	}
+	result, err := FindDecoder(frameHeader.HeaderType)(frameHeader.Flags, frameHeader.StreamId, data[9:], NewDecodingContext())
+	if err != nil {
+		t.Fatal("Decoding error:", err.Error())
+	}
+	return result
+}
+
+func TestAltSvcEncodeDecode(t *testing.T) {
+	frame := NewAltSvcFrame(0, "https://example.com", "h2=\":8443\"; ma=3600")
+	result := encodeAndDecode(t, frame)
+	if !reflect.DeepEqual(frame, result) {
+		t.Error("Result does not equal expected frame.")
+	}
+}
+
+func TestAltSvcValidity(t *testing.T) {
+	if NewAltSvcFrame(0, "", "h2=\":8443\"").IsValid() {
+		t.Error("ALTSVC on stream 0 without origin should be invalid.")
+	}
+	if NewAltSvcFrame(3, "https://example.com", "h2=\":8443\"").IsValid() {
+		t.Error("ALTSVC on stream 3 with origin should be invalid.")
+	}
+	if !NewAltSvcFrame(3, "", "h2=\":8443\"").IsValid() {
+		t.Error("ALTSVC on stream 3 without origin should be valid.")
+	}
+}
+
+func TestAltSvcTruncated(t *testing.T) {
+	_, err := DecodeAltSvcFrame(0, 0, []byte{0x00, 0x05, 'h', 't'}, NewDecodingContext())
+	if err == nil {
+		t.Error("Expected error for origin length exceeding the payload.")
+	}
+}
+
+func TestOriginEncodeDecode(t *testing.T) {
+	frame := NewOriginFrame(0, []string{"https://example.com", "https://cdn.example.com"})
+	result := encodeAndDecode(t, frame)
+	if !reflect.DeepEqual(frame, result) {
+		t.Error("Result does not equal expected frame.")
+	}
+}
@@ -3,25 +3,197 @@ package frames
 import (
 	"bytes"
 	"golang.org/x/net/http2/hpack"
+	"sync/atomic"
 )
 
 type EncodingContext struct {
 	headerBlockBuffer bytes.Buffer
+	headerFieldBuffer bytes.Buffer // output of the HPACK encoder, copied to headerBlockBuffer
 	encoder           *hpack.Encoder
+	maxFrameSize      uint32          // accessed atomically, because the wiretap updates it from another go routine.
+	huffman           bool            // If false, Huffman encoded strings are replaced with raw octets.
+	sensitiveHeaders  map[string]bool // Names of header fields that are always encoded as never indexed.
+	hpackTracker      *hpackTracker   // nil unless EnableHpackInfo() was called.
 }
 
 type DecodingContext struct {
-	decoder *hpack.Decoder
+	decoder      *hpack.Decoder
+	maxFrameSize uint32        // accessed atomically, because the wiretap updates it from another go routine.
+	hpackTracker *hpackTracker // nil unless EnableHpackInfo() was called.
 }
 
 func NewDecodingContext() *DecodingContext {
 	return &DecodingContext{
-		decoder: hpack.NewDecoder(4096, func(f hpack.HeaderField) {}),
+		decoder:      hpack.NewDecoder(4096, func(f hpack.HeaderField) {}),
+		maxFrameSize: 2 << 13, // Initial value of SETTINGS_MAX_FRAME_SIZE, see RFC 7540 section 6.5.2.
 	}
 }
 
+// SetHeaderTableSize should be called when we send SETTINGS_HEADER_TABLE_SIZE to the peer.
+// The peer's encoder may then use a dynamic table up to that size.
+func (c *DecodingContext) SetHeaderTableSize(size uint32) {
+	c.decoder.SetAllowedMaxDynamicTableSize(size)
+}
+
+// SetMaxFrameSize should be called when we send SETTINGS_MAX_FRAME_SIZE to the peer.
+// Received frames exceeding the max frame size are rejected with FRAME_SIZE_ERROR.
+func (c *DecodingContext) SetMaxFrameSize(size uint32) {
+	atomic.StoreUint32(&c.maxFrameSize, size)
+}
+
+func (c *DecodingContext) MaxFrameSize() uint32 {
+	return atomic.LoadUint32(&c.maxFrameSize)
+}
+
+// EnableHpackInfo makes decoded HEADERS, PUSH_PROMISE, and CONTINUATION frames carry an HpackInfo
+// describing the representation of each header field. This is useful for debugging.
+// It must be called before the first header block is decoded, because it mirrors the dynamic table.
+func (c *DecodingContext) EnableHpackInfo() {
+	c.hpackTracker = newHpackTracker()
+}
+
 func NewEncodingContext() *EncodingContext {
-	result := &EncodingContext{}
-	result.encoder = hpack.NewEncoder(&result.headerBlockBuffer)
+	result := &EncodingContext{
+		maxFrameSize: 2 << 13, // Minimum size that must be supported by all implementations.
+		huffman:      true,
+		sensitiveHeaders: map[string]bool{
+			"authorization":       true,
+			"proxy-authorization": true,
+		},
+	}
+	result.encoder = hpack.NewEncoder(&result.headerFieldBuffer)
 	return result
 }
+
+// SetHeaderTableSize should be called when the peer sends SETTINGS_HEADER_TABLE_SIZE.
+// The encoder adapts its dynamic table size and emits a dynamic table size update
+// at the beginning of the next header block, see RFC 7541 section 4.2.
+func (c *EncodingContext) SetHeaderTableSize(size uint32) {
+	c.encoder.SetMaxDynamicTableSizeLimit(size)
+	c.encoder.SetMaxDynamicTableSize(size)
+}
+
+// SetHuffman turns Huffman encoding of header names and values on or off.
+// This is useful for debugging, because header fields can be read in hex dumps.
+func (c *EncodingContext) SetHuffman(enabled bool) {
+	c.huffman = enabled
+}
+
+// SetSensitive configures whether header fields with that name are encoded with the
+// never indexed representation, see RFC 7541 section 7.1.3. By default, this is the case for
+// authorization and proxy-authorization. Header fields with Sensitive set are always encoded as never indexed.
+func (c *EncodingContext) SetSensitive(name string, sensitive bool) {
+	c.sensitiveHeaders[name] = sensitive
+}
+
+// SetMaxFrameSize should be called when the peer sends SETTINGS_MAX_FRAME_SIZE.
+// Header blocks exceeding the max frame size will be split into CONTINUATION frames.
+func (c *EncodingContext) SetMaxFrameSize(size uint32) {
+	atomic.StoreUint32(&c.maxFrameSize, size)
+}
+
+func (c *EncodingContext) MaxFrameSize() uint32 {
+	return atomic.LoadUint32(&c.maxFrameSize)
+}
+
+// EnableHpackInfo makes Encode() set the HpackInfo of HEADERS, PUSH_PROMISE, and CONTINUATION frames.
+// It must be called before the first header block is encoded, because it mirrors the dynamic table.
+func (c *EncodingContext) EnableHpackInfo() {
+	c.hpackTracker = newHpackTracker()
+}
+
+// encodedHeaderBlock is a header block that is split into the fragments of a HEADERS or PUSH_PROMISE frame
+// and the following CONTINUATION frames.
+type encodedHeaderBlock struct {
+	data      []byte              // The part of the header block not yet returned by nextFragment().
+	offset    int                 // Offset of data in the header block.
+	headers   []hpack.HeaderField // The header fields not completed before offset.
+	fieldEnds []int               // Offset in the header block after each of the headers.
+}
+
+// nextFragment removes up to maxSize bytes from the beginning of the header block,
+// and returns these bytes together with the header fields completed within them.
+func (b *encodedHeaderBlock) nextFragment(maxSize int) ([]byte, []hpack.HeaderField) {
+	size := len(b.data)
+	if size > maxSize {
+		size = maxSize
+	}
+	b.offset += size
+	nCompleted := 0
+	for nCompleted < len(b.fieldEnds) && b.fieldEnds[nCompleted] <= b.offset {
+		nCompleted++
+	}
+	fragment, headers := b.data[:size], b.headers[:nCompleted]
+	b.data, b.headers, b.fieldEnds = b.data[size:], b.headers[nCompleted:], b.fieldEnds[nCompleted:]
+	return fragment, headers
+}
+
+func (b *encodedHeaderBlock) isEmpty() bool {
+	return len(b.data) == 0
+}
+
+// The header block is encoded into c.headerBlockBuffer. The caller must reset the buffer when done.
+func (c *EncodingContext) encodeHeaderBlock(headers []hpack.HeaderField) (*encodedHeaderBlock, error) {
+	encodedHeaders := make([]hpack.HeaderField, 0, len(headers))
+	fieldEnds := make([]int, 0, len(headers))
+	for _, header := range headers {
+		if c.sensitiveHeaders[header.Name] {
+			header.Sensitive = true
+		}
+		c.headerFieldBuffer.Reset()
+		err := c.encoder.WriteField(header)
+		if err != nil {
+			return nil, err
+		}
+		encoded := c.headerFieldBuffer.Bytes()
+		if !c.huffman {
+			encoded, err = removeHuffmanEncoding(encoded)
+			if err != nil {
+				return nil, err
+			}
+		}
+		c.headerBlockBuffer.Write(encoded)
+		encodedHeaders = append(encodedHeaders, header)
+		fieldEnds = append(fieldEnds, c.headerBlockBuffer.Len())
+	}
+	return &encodedHeaderBlock{
+		data:      c.headerBlockBuffer.Bytes(),
+		headers:   encodedHeaders,
+		fieldEnds: fieldEnds,
+	}, nil
+}
+
+// analyzeHeaderBlockFragment returns nil unless EnableHpackInfo() was called.
+func (c *EncodingContext) analyzeHeaderBlockFragment(fragment []byte, headers []hpack.HeaderField, endHeaders bool) *HpackInfo {
+	if c.hpackTracker == nil {
+		return nil
+	}
+	return c.hpackTracker.analyze(fragment, headers, endHeaders)
+}
+
+// A header block may be split into a HEADERS or PUSH_PROMISE frame followed by CONTINUATION frames.
+// The HPACK decoder keeps incomplete header fields between calls, so each frame can be decoded
+// as soon as it arrives. The result contains only the header fields completed by this fragment.
+// The HpackInfo is nil unless EnableHpackInfo() was called.
+func (c *DecodingContext) decodeHeaderBlockFragment(fragment []byte, endHeaders bool) ([]hpack.HeaderField, *HpackInfo, error) {
+	headers := make([]hpack.HeaderField, 0)
+	c.decoder.SetEmitFunc(func(f hpack.HeaderField) {
+		headers = append(headers, f)
+	})
+	defer c.decoder.SetEmitFunc(func(f hpack.HeaderField) {})
+	_, err := c.decoder.Write(fragment)
+	if err != nil {
+		return nil, nil, err
+	}
+	if endHeaders {
+		err = c.decoder.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	var info *HpackInfo
+	if c.hpackTracker != nil {
+		info = c.hpackTracker.analyze(fragment, headers, endHeaders)
+	}
+	return headers, info, nil
+}
@@ -0,0 +1,151 @@
+package frames
+
+import (
+	"bytes"
+	"fmt"
+	"golang.org/x/net/http2/hpack"
+)
+
+const (
+	CONTINUATION_FLAG_END_HEADERS Flag = 0x04
+)
+
+// A ContinuationFrame carries the header fields that did not fit into the preceding
+// HEADERS, PUSH_PROMISE, or CONTINUATION frame.
+//
+// When decoding, Headers contains only the header fields completed in this frame.
+type ContinuationFrame struct {
+	StreamId   uint32
+	EndHeaders bool
+	Headers    []hpack.HeaderField
+	HpackInfo  *HpackInfo // Set by the decoder or by Encode() if enabled with EnableHpackInfo().
+}
+
+func NewContinuationFrame(streamId uint32, headers []hpack.HeaderField) *ContinuationFrame {
+	return &ContinuationFrame{
+		StreamId:   streamId,
+		EndHeaders: true,
+		Headers:    headers,
+	}
+}
+
+func DecodeContinuationFrame(flags byte, streamId uint32, payload []byte, context *DecodingContext) (Frame, error) {
+	if err := requireStreamId(CONTINUATION_TYPE, streamId); err != nil {
+		return nil, err
+	}
+	endHeaders := CONTINUATION_FLAG_END_HEADERS.isSet(flags)
+	headers, hpackInfo, err := context.decodeHeaderBlockFragment(payload, endHeaders)
+	if err != nil {
+		return nil, newConnectionError(COMPRESSION_ERROR, "Error decoding header fields: %v", err.Error())
+	}
+	return &ContinuationFrame{
+		StreamId:   streamId,
+		EndHeaders: endHeaders,
+		Headers:    headers,
+		HpackInfo:  hpackInfo,
+	}, nil
+}
+
+func (f *ContinuationFrame) Type() Type {
+	return CONTINUATION_TYPE
+}
+
+func (f *ContinuationFrame) Encode(context *EncodingContext) ([]byte, error) {
+	data, _, err := f.encodeFrames(context)
+	return data, err
+}
+
+func (f *ContinuationFrame) encodeFrames(context *EncodingContext) ([]byte, []Frame, error) {
+	defer context.headerBlockBuffer.Reset()
+	headerBlock, err := context.encodeHeaderBlock(f.Headers)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to encode %v frame: %v", f.Type(), err)
+	}
+	data, encodedFrames := encodeHeaderBlockFrames(f, []Flag{}, nil, headerBlock, nil, f.EndHeaders, context)
+	return data, encodedFrames, nil
+}
+
+func (f *ContinuationFrame) GetStreamId() uint32 {
+	return f.StreamId
+}
+
+// encodeHeaderBlockFrames encodes frame with flags, containing prefix, the header block, and suffix.
+// If the result would exceed the max frame size, the rest of the header block is sent in CONTINUATION frames.
+// The END_HEADERS flag is set on the last frame if endHeaders is true.
+// END_HEADERS has the value 0x04 in HEADERS, PUSH_PROMISE, and CONTINUATION frames.
+//
+// The encoded frames are returned, too. If the header block was split, these are a copy of frame followed by
+// the CONTINUATION frames, each containing only the header fields completed in that frame, like decoded frames.
+// If enabled with EnableHpackInfo(), each of these frames gets the HpackInfo of its fragment,
+// and frame gets the HpackInfo of the complete header block.
+func encodeHeaderBlockFrames(frame Frame, flags []Flag, prefix []byte, headerBlock *encodedHeaderBlock, suffix []byte, endHeaders bool, context *EncodingContext) ([]byte, []Frame) {
+	var result bytes.Buffer
+	fragment, headers := headerBlock.nextFragment(int(context.MaxFrameSize()) - len(prefix) - len(suffix))
+	hpackInfo := context.analyzeHeaderBlockFragment(fragment, headers, headerBlock.isEmpty())
+	result.Write(encodeHeader(frame.Type(), frame.GetStreamId(), uint32(len(prefix)+len(fragment)+len(suffix)), withEndHeaders(flags, endHeaders && headerBlock.isEmpty())))
+	result.Write(prefix)
+	result.Write(fragment)
+	result.Write(suffix)
+	if headerBlock.isEmpty() {
+		setHpackInfo(frame, hpackInfo)
+		return result.Bytes(), []Frame{frame}
+	}
+	encodedFrames := []Frame{withHeaderBlockFragment(frame, headers, hpackInfo)}
+	for !headerBlock.isEmpty() {
+		fragment, headers = headerBlock.nextFragment(int(context.MaxFrameSize()))
+		continuation := &ContinuationFrame{
+			StreamId:   frame.GetStreamId(),
+			EndHeaders: endHeaders && headerBlock.isEmpty(),
+			Headers:    headers,
+			HpackInfo:  context.analyzeHeaderBlockFragment(fragment, headers, headerBlock.isEmpty()),
+		}
+		result.Write(encodeHeader(CONTINUATION_TYPE, continuation.StreamId, uint32(len(fragment)), withEndHeaders([]Flag{}, continuation.EndHeaders)))
+		result.Write(fragment)
+		encodedFrames = append(encodedFrames, continuation)
+		hpackInfo = appendHpackInfo(hpackInfo, continuation.HpackInfo)
+	}
+	setHpackInfo(frame, hpackInfo)
+	return result.Bytes(), encodedFrames
+}
+
+// withHeaderBlockFragment returns a copy of frame carrying only the header fields of the first fragment.
+func withHeaderBlockFragment(frame Frame, headers []hpack.HeaderField, hpackInfo *HpackInfo) Frame {
+	switch frame := frame.(type) {
+	case *HeadersFrame:
+		result := *frame
+		result.Headers, result.HpackInfo, result.EndHeaders = headers, hpackInfo, false
+		return &result
+	case *PushPromiseFrame:
+		result := *frame
+		result.Headers, result.HpackInfo, result.EndHeaders = headers, hpackInfo, false
+		return &result
+	case *ContinuationFrame:
+		result := *frame
+		result.Headers, result.HpackInfo, result.EndHeaders = headers, hpackInfo, false
+		return &result
+	default:
+		return frame
+	}
+}
+
+// setHpackInfo does nothing if hpackInfo is nil, i.e. if EnableHpackInfo() was not called.
+func setHpackInfo(frame Frame, hpackInfo *HpackInfo) {
+	if hpackInfo == nil {
+		return
+	}
+	switch frame := frame.(type) {
+	case *HeadersFrame:
+		frame.HpackInfo = hpackInfo
+	case *PushPromiseFrame:
+		frame.HpackInfo = hpackInfo
+	case *ContinuationFrame:
+		frame.HpackInfo = hpackInfo
+	}
+}
+
+func withEndHeaders(flags []Flag, endHeaders bool) []Flag {
+	if endHeaders {
+		return append(flags, CONTINUATION_FLAG_END_HEADERS)
+	}
+	return flags
+}
@@ -0,0 +1,151 @@
+package frames
+
+import (
+	"golang.org/x/net/http2/hpack"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func makeLargeHeaders() []hpack.HeaderField {
+	return []hpack.HeaderField{
+		hpack.HeaderField{Name: ":authority", Value: "localhost"},
+		hpack.HeaderField{Name: ":method", Value: "GET"},
+		hpack.HeaderField{Name: ":path", Value: "/index.html"},
+		hpack.HeaderField{Name: ":scheme", Value: "https"},
+		hpack.HeaderField{Name: "cookie", Value: strings.Repeat("a", 20000)},
+		hpack.HeaderField{Name: "x-custom", Value: strings.Repeat("b", 20000)},
+	}
+}
+
+// Decode all frames in data, and return the concatenated headers of the HEADERS and CONTINUATION frames.
+func decodeHeaderBlockFrames(t *testing.T, data []byte, maxFrameSize uint32) ([]Type, []hpack.HeaderField) {
+	context := NewDecodingContext()
+	types := make([]Type, 0)
+	headers := make([]hpack.HeaderField, 0)
+	for len(data) > 0 {
+		frameHeader := DecodeHeader(data[0:9])
+		if frameHeader.Length > maxFrameSize {
+			t.Fatalf("Frame length %v exceeds max frame size %v.", frameHeader.Length, maxFrameSize)
+		}
+		payload := data[9 : 9+frameHeader.Length]
+		data = data[9+frameHeader.Length:]
+		frame, err := FindDecoder(frameHeader.HeaderType)(frameHeader.Flags, frameHeader.StreamId, payload, context)
+		if err != nil {
+			t.Fatal("Decoding error:", err.Error())
+		}
+		types = append(types, frame.Type())
+		switch frame := frame.(type) {
+		case *HeadersFrame:
+			if frame.EndHeaders != (len(data) == 0) {
+				t.Error("END_HEADERS must be set on the last frame only.")
+			}
+			headers = append(headers, frame.Headers...)
+		case *ContinuationFrame:
+			if frame.EndHeaders != (len(data) == 0) {
+				t.Error("END_HEADERS must be set on the last frame only.")
+			}
+			headers = append(headers, frame.Headers...)
+		default:
+			t.Fatalf("Unexpected frame type %v.", frame.Type())
+		}
+	}
+	return types, headers
+}
+
+func TestSplitIntoContinuationFrames(t *testing.T) {
+	frame := NewHeadersFrame(31, makeLargeHeaders())
+	data, err := frame.Encode(NewEncodingContext())
+	if err != nil {
+		t.Fatal("Encoding error:", err.Error())
+	}
+	types, headers := decodeHeaderBlockFrames(t, data, 2<<13)
+	if len(types) < 2 || types[0] != HEADERS_TYPE {
+		t.Errorf("Expected HEADERS followed by CONTINUATION frames, but got %v.", types)
+	}
+	for _, frameType := range types[1:] {
+		if frameType != CONTINUATION_TYPE {
+			t.Errorf("Expected HEADERS followed by CONTINUATION frames, but got %v.", types)
+		}
+	}
+	if !reflect.DeepEqual(headers, frame.Headers) {
+		t.Error("Decoded headers do not equal the original headers.")
+	}
+}
+
+func TestEncodeFramesReturnsContinuationFrames(t *testing.T) {
+	frame := NewHeadersFrame(31, makeLargeHeaders())
+	data, encodedFrames, err := EncodeFrames(frame, NewEncodingContext())
+	if err != nil {
+		t.Fatal("Encoding error:", err.Error())
+	}
+	// The returned frames must be equal to the frames decoded from data.
+	context := NewDecodingContext()
+	decodedFrames := make([]Frame, 0)
+	for len(data) > 0 {
+		frameHeader := DecodeHeader(data[0:9])
+		payload := data[9 : 9+frameHeader.Length]
+		data = data[9+frameHeader.Length:]
+		decoded, err := FindDecoder(frameHeader.HeaderType)(frameHeader.Flags, frameHeader.StreamId, payload, context)
+		if err != nil {
+			t.Fatal("Decoding error:", err.Error())
+		}
+		decodedFrames = append(decodedFrames, decoded)
+	}
+	if len(decodedFrames) < 2 {
+		t.Fatalf("Expected the header block to be split into CONTINUATION frames, but got %v frames.", len(decodedFrames))
+	}
+	if !reflect.DeepEqual(encodedFrames, decodedFrames) {
+		t.Error("Encoded frames do not equal the decoded frames.")
+	}
+	if len(frame.Headers) != len(makeLargeHeaders()) || !frame.EndHeaders {
+		t.Error("The original frame must not be modified.")
+	}
+}
+
+func TestEncodeFramesWithoutContinuation(t *testing.T) {
+	frame := NewHeadersFrame(31, makeLargeHeaders()[:4])
+	_, encodedFrames, err := EncodeFrames(frame, NewEncodingContext())
+	if err != nil {
+		t.Fatal("Encoding error:", err.Error())
+	}
+	if len(encodedFrames) != 1 || encodedFrames[0] != frame {
+		t.Errorf("Expected only the original frame, but got %v.", encodedFrames)
+	}
+}
+
+func TestNoSplitWithLargeMaxFrameSize(t *testing.T) {
+	frame := NewHeadersFrame(31, makeLargeHeaders())
+	context := NewEncodingContext()
+	context.SetMaxFrameSize(2 << 16)
+	data, err := frame.Encode(context)
+	if err != nil {
+		t.Fatal("Encoding error:", err.Error())
+	}
+	types, headers := decodeHeaderBlockFrames(t, data, 2<<16)
+	if !reflect.DeepEqual(types, []Type{HEADERS_TYPE}) {
+		t.Errorf("Expected a single HEADERS frame, but got %v.", types)
+	}
+	if !reflect.DeepEqual(headers, frame.Headers) {
+		t.Error("Decoded headers do not equal the original headers.")
+	}
+}
+
+func TestContinuationEncodeDecode(t *testing.T) {
+	headers := []hpack.HeaderField{
+		hpack.HeaderField{Name: "content-type", Value: "text/plain"},
+	}
+	frame := NewContinuationFrame(31, headers)
+	data, err := frame.Encode(NewEncodingContext())
+	if err != nil {
+		t.Fatal("Encoding error:", err.Error())
+	}
+	frameHeader := DecodeHeader(data[0:9])
+	result, err := DecodeContinuationFrame(frameHeader.Flags, frameHeader.StreamId, data[9:], NewDecodingContext())
+	if err != nil {
+		t.Fatal("Decoding error:", err.Error())
+	}
+	if !reflect.DeepEqual(frame, result) {
+		t.Error("Result does not equal expected frame.")
+	}
+}
@@ -13,6 +13,8 @@ type DataFrame struct {
 	StreamId  uint32
 	Data      []byte
 	EndStream bool
+	Padded    bool
+	PadLength uint8 // Number of padding bytes, only used if Padded is true.
 }
 
 func NewDataFrame(streamId uint32, data []byte, endStream bool) *DataFrame {
@@ -24,17 +26,33 @@ func NewDataFrame(streamId uint32, data []byte, endStream bool) *DataFrame {
 }
 
 func DecodeDataFrame(flags byte, streamId uint32, framePayload []byte, context *DecodingContext) (Frame, error) {
+	if err := requireStreamId(DATA_TYPE, streamId); err != nil {
+		return nil, err
+	}
 	endStream := DATA_FLAG_END_STREAM.isSet(flags)
 	padded := DATA_FLAG_PADDED.isSet(flags)
 	payload := framePayload
+	var padLength uint8
 	var err error
 	if padded {
-		payload, err = stripPadding(payload)
+		payload, padLength, err = stripPadding(DATA_TYPE, payload)
 		if err != nil {
 			return nil, err
 		}
 	}
-	return NewDataFrame(streamId, payload, endStream), nil
+	result := NewDataFrame(streamId, payload, endStream)
+	result.Padded = padded
+	result.PadLength = padLength
+	return result, nil
+}
+
+// PayloadLength is the length of the frame payload including padding.
+// The entire payload counts against the flow-control windows, see RFC 7540 section 6.9.1.
+func (f *DataFrame) PayloadLength() int {
+	if f.Padded {
+		return 1 + len(f.Data) + int(f.PadLength)
+	}
+	return len(f.Data)
 }
 
 func (f *DataFrame) Type() Type {
@@ -46,14 +64,24 @@ func (f *DataFrame) flags() []Flag {
 	if f.EndStream {
 		flags = append(flags, DATA_FLAG_END_STREAM)
 	}
+	if f.Padded {
+		flags = append(flags, DATA_FLAG_PADDED)
+	}
 	return flags
 }
 
 func (f *DataFrame) Encode(context *EncodingContext) ([]byte, error) {
-	length := uint32(len(f.Data))
+	length := uint32(f.PayloadLength())
 	var result bytes.Buffer
 	result.Write(encodeHeader(f.Type(), f.StreamId, length, f.flags()))
-	result.Write(f.Data)
+	if f.Padded {
+		padLength, padding := makePadding(f.PadLength)
+		result.Write(padLength)
+		result.Write(f.Data)
+		result.Write(padding)
+	} else {
+		result.Write(f.Data)
+	}
 	return result.Bytes(), nil
 }
 
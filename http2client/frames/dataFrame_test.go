@@ -0,0 +1,30 @@
+package frames
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDataFramePadding(t *testing.T) {
+	frame := NewDataFrame(3, []byte("hello"), true)
+	frame.Padded = true
+	frame.PadLength = 10
+	if frame.PayloadLength() != 1+5+10 {
+		t.Errorf("Expected payload length 16, but got %v.", frame.PayloadLength())
+	}
+	data, err := frame.Encode(NewEncodingContext())
+	if err != nil {
+		t.Fatal("Encoding error:", err.Error())
+	}
+	frameHeader := DecodeHeader(data[0:9])
+	if frameHeader.Length != 16 || !DATA_FLAG_PADDED.isSet(frameHeader.Flags) {
+		t.Error("Expected PADDED flag and length 16.")
+	}
+	result, err := DecodeDataFrame(frameHeader.Flags, frameHeader.StreamId, data[9:], NewDecodingContext())
+	if err != nil {
+		t.Fatal("Decoding error:", err.Error())
+	}
+	if !reflect.DeepEqual(frame, result) {
+		t.Error("Result does not equal expected frame.")
+	}
+}
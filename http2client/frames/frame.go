@@ -19,7 +19,9 @@ const (
 	PING_TYPE          Type = 0x06
 	GOAWAY_TYPE        Type = 0x07
 	WINDOW_UPDATE_TYPE Type = 0x08
-	// TODO: CONTINUATION_TYPE
+	CONTINUATION_TYPE  Type = 0x09
+	ALTSVC_TYPE        Type = 0x0a // RFC 7838
+	ORIGIN_TYPE        Type = 0x0c // RFC 8336
 )
 
 type Frame interface {
@@ -28,6 +30,24 @@ type Frame interface {
 	GetStreamId() uint32
 }
 
+// headerBlockFrame is implemented by frames carrying a header block, which may be split into CONTINUATION frames.
+type headerBlockFrame interface {
+	encodeFrames(*EncodingContext) ([]byte, []Frame, error)
+}
+
+// EncodeFrames encodes the frame like Frame.Encode(), and also returns the frames contained in the result.
+// If a header block was split, these are the HEADERS or PUSH_PROMISE frame followed by CONTINUATION frames,
+// each containing only the header fields completed in that frame. Otherwise, the result contains just the frame.
+func EncodeFrames(frame Frame, context *EncodingContext) ([]byte, []Frame, error) {
+	if frame, isHeaderBlockFrame := frame.(headerBlockFrame); isHeaderBlockFrame {
+		return frame.encodeFrames(context)
+	}
+	data, err := frame.Encode(context)
+	return data, []Frame{frame}, err
+}
+
+// FindDecoder returns the decode function for the frame type.
+// Frame types not implemented in h2c are decoded as UnknownFrame.
 func FindDecoder(frameType Type) func(flags byte, streamId uint32, payload []byte, context *DecodingContext) (Frame, error) {
 	switch frameType {
 	case DATA_TYPE:
@@ -48,8 +68,14 @@ func FindDecoder(frameType Type) func(flags byte, streamId uint32, payload []byt
 		return DecodeGoAwayFrame
 	case WINDOW_UPDATE_TYPE:
 		return DecodeWindowUpdateFrame
+	case CONTINUATION_TYPE:
+		return DecodeContinuationFrame
+	case ALTSVC_TYPE:
+		return DecodeAltSvcFrame
+	case ORIGIN_TYPE:
+		return DecodeOriginFrame
 	default:
-		return nil
+		return makeUnknownFrameDecoder(frameType)
 	}
 }
 
@@ -94,13 +120,23 @@ func (flag Flag) set(flagsByte *byte) {
 	*flagsByte = *flagsByte | byte(flag)
 }
 
-func stripPadding(payload []byte) ([]byte, error) {
+// stripPadding returns the payload without the Pad Length field and without padding, and the pad length.
+// Padding that is not shorter than the payload is a connection error of type PROTOCOL_ERROR, see RFC 7540 section 6.1.
+func stripPadding(frameType Type, payload []byte) ([]byte, uint8, error) {
+	if len(payload) == 0 {
+		return nil, 0, newConnectionError(FRAME_SIZE_ERROR, "Received %v frame with PADDED flag, but Pad Length field missing.", frameType)
+	}
 	padLength := int(payload[0])
 	if len(payload) <= padLength {
-		// TODO: trigger connection error.
-		return nil, fmt.Errorf("Invalid HEADERS frame: padding >= payload.")
+		return nil, 0, newConnectionError(PROTOCOL_ERROR, "Received %v frame with pad length %v, but payload length is only %v.", frameType, padLength, len(payload))
 	}
-	return payload[1 : len(payload)-padLength], nil
+	return payload[1 : len(payload)-padLength], uint8(padLength), nil
+}
+
+// makePadding returns the Pad Length field and the padding, see RFC 7540 section 6.1.
+// The padding must be written after the payload.
+func makePadding(padLength uint8) ([]byte, []byte) {
+	return []byte{padLength}, make([]byte, padLength)
 }
 
 func (t Type) String() string {
@@ -123,6 +159,12 @@ func (t Type) String() string {
 		return "GOAWAY"
 	case WINDOW_UPDATE_TYPE:
 		return "WINDOW_UPDATE"
+	case CONTINUATION_TYPE:
+		return "CONTINUATION"
+	case ALTSVC_TYPE:
+		return "ALTSVC"
+	case ORIGIN_TYPE:
+		return "ORIGIN"
 	default:
 		return fmt.Sprintf("'UNKNOWN TYPE 0x%02X'", byte(t))
 	}
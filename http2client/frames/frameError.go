@@ -0,0 +1,57 @@
+package frames
+
+import (
+	"fmt"
+)
+
+// FrameError is returned by the decoders if a received frame violates RFC 7540.
+//
+// If IsConnectionError is true, the endpoint must close the connection with a GOAWAY frame carrying ErrorCode.
+// Otherwise, only the stream StreamId must be closed with a RST_STREAM frame carrying ErrorCode,
+// see RFC 7540 section 5.4.
+type FrameError struct {
+	ErrorCode         ErrorCode
+	IsConnectionError bool
+	StreamId          uint32
+	Message           string
+}
+
+func (err *FrameError) Error() string {
+	if err.IsConnectionError {
+		return fmt.Sprintf("Connection error %v: %v", err.ErrorCode, err.Message)
+	}
+	return fmt.Sprintf("Stream error %v on stream %v: %v", err.ErrorCode, err.StreamId, err.Message)
+}
+
+func newConnectionError(errorCode ErrorCode, format string, a ...interface{}) *FrameError {
+	return &FrameError{
+		ErrorCode:         errorCode,
+		IsConnectionError: true,
+		Message:           fmt.Sprintf(format, a...),
+	}
+}
+
+func newStreamError(streamId uint32, errorCode ErrorCode, format string, a ...interface{}) *FrameError {
+	return &FrameError{
+		ErrorCode:         errorCode,
+		IsConnectionError: false,
+		StreamId:          streamId,
+		Message:           fmt.Sprintf(format, a...),
+	}
+}
+
+// Frames that are associated with a stream must not have stream id 0.
+func requireStreamId(frameType Type, streamId uint32) error {
+	if streamId == 0 {
+		return newConnectionError(PROTOCOL_ERROR, "Received %v frame with stream id 0.", frameType)
+	}
+	return nil
+}
+
+// Frames that apply to the connection as a whole must have stream id 0.
+func requireStreamIdZero(frameType Type, streamId uint32) error {
+	if streamId != 0 {
+		return newConnectionError(PROTOCOL_ERROR, "Received %v frame with stream id %v.", frameType, streamId)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package frames
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func decode(frameType Type, flags byte, streamId uint32, payload []byte) (Frame, error) {
+	return FindDecoder(frameType)(flags, streamId, payload, NewDecodingContext())
+}
+
+func assertFrameError(t *testing.T, err error, errorCode ErrorCode, isConnectionError bool) {
+	frameErr, ok := err.(*FrameError)
+	if !ok {
+		t.Errorf("Expected *FrameError with error code %v, but got %v.", errorCode, err)
+		return
+	}
+	if frameErr.ErrorCode != errorCode || frameErr.IsConnectionError != isConnectionError {
+		t.Errorf("Expected error code %v (connection error: %v), but got %v.", errorCode, isConnectionError, frameErr)
+	}
+}
+
+func TestInvalidFrameLength(t *testing.T) {
+	_, err := decode(PING_TYPE, 0, 0, make([]byte, 7))
+	assertFrameError(t, err, FRAME_SIZE_ERROR, true)
+	_, err = decode(WINDOW_UPDATE_TYPE, 0, 3, make([]byte, 5))
+	assertFrameError(t, err, FRAME_SIZE_ERROR, true)
+	_, err = decode(RST_STREAM_TYPE, 0, 3, make([]byte, 3))
+	assertFrameError(t, err, FRAME_SIZE_ERROR, true)
+	_, err = decode(SETTINGS_TYPE, 0, 0, make([]byte, 7))
+	assertFrameError(t, err, FRAME_SIZE_ERROR, true)
+	_, err = decode(SETTINGS_TYPE, byte(SETTINGS_FLAG_ACK), 0, make([]byte, 6))
+	assertFrameError(t, err, FRAME_SIZE_ERROR, true)
+	_, err = decode(PRIORITY_TYPE, 0, 3, make([]byte, 4))
+	assertFrameError(t, err, FRAME_SIZE_ERROR, false)
+	_, err = decode(GOAWAY_TYPE, 0, 0, make([]byte, 7))
+	assertFrameError(t, err, FRAME_SIZE_ERROR, true)
+}
+
+func TestInvalidStreamId(t *testing.T) {
+	for _, frameType := range []Type{DATA_TYPE, HEADERS_TYPE, PRIORITY_TYPE, RST_STREAM_TYPE, PUSH_PROMISE_TYPE, CONTINUATION_TYPE} {
+		_, err := decode(frameType, 0, 0, make([]byte, 5))
+		assertFrameError(t, err, PROTOCOL_ERROR, true)
+	}
+	_, err := decode(SETTINGS_TYPE, 0, 1, []byte{})
+	assertFrameError(t, err, PROTOCOL_ERROR, true)
+	_, err = decode(PING_TYPE, 0, 1, make([]byte, 8))
+	assertFrameError(t, err, PROTOCOL_ERROR, true)
+	_, err = decode(GOAWAY_TYPE, 0, 1, make([]byte, 8))
+	assertFrameError(t, err, PROTOCOL_ERROR, true)
+}
+
+func TestWindowUpdateZeroIncrement(t *testing.T) {
+	_, err := decode(WINDOW_UPDATE_TYPE, 0, 0, make([]byte, 4))
+	assertFrameError(t, err, PROTOCOL_ERROR, true)
+	_, err = decode(WINDOW_UPDATE_TYPE, 0, 3, make([]byte, 4))
+	assertFrameError(t, err, PROTOCOL_ERROR, false)
+	if err.(*FrameError).StreamId != 3 {
+		t.Error("Expected stream error for stream 3.")
+	}
+}
+
+func TestInvalidPadding(t *testing.T) {
+	_, err := decode(DATA_TYPE, byte(DATA_FLAG_PADDED), 3, []byte{0x03, 0x00, 0x00})
+	assertFrameError(t, err, PROTOCOL_ERROR, true)
+	_, err = decode(DATA_TYPE, byte(DATA_FLAG_PADDED), 3, []byte{})
+	assertFrameError(t, err, FRAME_SIZE_ERROR, true)
+}
+
+func TestUnknownSettingIsKept(t *testing.T) {
+	payload := []byte{
+		0x00, 0x05, 0x00, 0x00, 0x40, 0x00, // SETTINGS_MAX_FRAME_SIZE = 16384
+		0xf0, 0x0d, 0x00, 0x00, 0x00, 0x01, // unknown setting
+	}
+	frame, err := decode(SETTINGS_TYPE, 0, 0, payload)
+	if err != nil {
+		t.Fatal("Decoding error:", err.Error())
+	}
+	expected := NewSettingsFrame(0, false)
+	expected.Settings[SETTINGS_MAX_FRAME_SIZE] = 2 << 13
+	expected.Settings[Setting(0xf00d)] = 1
+	if !reflect.DeepEqual(frame, expected) {
+		t.Error("Result does not equal expected frame.")
+	}
+	if Setting(0xf00d).String() != "UNKNOWN_SETTING(0xF00D)" {
+		t.Errorf("Unexpected name %v for unknown setting.", Setting(0xf00d))
+	}
+}
+
+func TestUnknownSettingIsForwarded(t *testing.T) {
+	data := []byte{
+		0x00, 0x00, 0x06, // length
+		0x04,                   // type
+		0x00,                   // flags
+		0x00, 0x00, 0x00, 0x00, // stream id
+		0xf0, 0x0d, 0x00, 0x00, 0x00, 0x01, // unknown setting
+	}
+	frame, err := decode(SETTINGS_TYPE, 0, 0, data[9:])
+	if err != nil {
+		t.Fatal("Decoding error:", err.Error())
+	}
+	encoded, err := frame.Encode(NewEncodingContext())
+	if err != nil {
+		t.Fatal("Encoding error:", err.Error())
+	}
+	if !bytes.Equal(encoded, data) {
+		t.Errorf("Expected %v, but got %v.", data, encoded)
+	}
+}
+
+func TestInvalidSettingValue(t *testing.T) {
+	_, err := decode(SETTINGS_TYPE, 0, 0, []byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x02})
+	assertFrameError(t, err, PROTOCOL_ERROR, true)
+	_, err = decode(SETTINGS_TYPE, 0, 0, []byte{0x00, 0x04, 0x80, 0x00, 0x00, 0x00})
+	assertFrameError(t, err, FLOW_CONTROL_ERROR, true)
+	_, err = decode(SETTINGS_TYPE, 0, 0, []byte{0x00, 0x05, 0x00, 0x00, 0x10, 0x00})
+	assertFrameError(t, err, PROTOCOL_ERROR, true)
+}
+
+func TestRstStreamAndGoAwayEncodeDecode(t *testing.T) {
+	goAwayWithDebugData := NewGoAwayFrame(0, 7, NO_ERROR)
+	goAwayWithDebugData.AdditionalDebugData = []byte("graceful shutdown")
+	for _, frame := range []Frame{
+		NewRstStreamFrame(3, CANCEL),
+		NewGoAwayFrame(0, 5, ENHANCE_YOUR_CALM),
+		goAwayWithDebugData,
+		NewPriorityFrame(5, 3, 200, true),
+	} {
+		result := encodeAndDecode(t, frame)
+		if !reflect.DeepEqual(frame, result) {
+			t.Errorf("Result does not equal expected %v frame.", frame.Type())
+		}
+	}
+}
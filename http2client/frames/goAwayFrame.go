@@ -1,14 +1,15 @@
 package frames
 
 import (
+	"bytes"
 	"encoding/binary"
-	"fmt"
 )
 
 type GoAwayFrame struct {
-	StreamId     uint32
-	LastStreamId uint32
-	ErrorCode    ErrorCode
+	StreamId            uint32
+	LastStreamId        uint32
+	ErrorCode           ErrorCode
+	AdditionalDebugData []byte // Opaque data for diagnostic purposes, see RFC 7540 section 6.8.
 }
 
 func NewGoAwayFrame(streamId uint32, lastStreamId uint32, errorCode ErrorCode) *GoAwayFrame {
@@ -20,12 +21,19 @@ func NewGoAwayFrame(streamId uint32, lastStreamId uint32, errorCode ErrorCode) *
 }
 
 func DecodeGoAwayFrame(flags byte, streamId uint32, payload []byte, context *DecodingContext) (Frame, error) {
+	if err := requireStreamIdZero(GOAWAY_TYPE, streamId); err != nil {
+		return nil, err
+	}
 	if len(payload) < 8 {
-		return nil, fmt.Errorf("FRAME_SIZE_ERROR: Received GOAWAY frame of length %v", len(payload))
+		return nil, newConnectionError(FRAME_SIZE_ERROR, "Received %v frame of length %v.", GOAWAY_TYPE, len(payload))
 	}
 	lastStreamId := uint32_ignoreFirstBit(payload[0:4])
 	errorCode := ErrorCode(binary.BigEndian.Uint32(payload[4:8]))
-	return NewGoAwayFrame(streamId, lastStreamId, errorCode), nil
+	result := NewGoAwayFrame(streamId, lastStreamId, errorCode)
+	if len(payload) > 8 {
+		result.AdditionalDebugData = payload[8:]
+	}
+	return result, nil
 }
 
 func (f *GoAwayFrame) Type() Type {
@@ -33,10 +41,14 @@ func (f *GoAwayFrame) Type() Type {
 }
 
 func (f *GoAwayFrame) Encode(context *EncodingContext) ([]byte, error) {
-	payload := make([]byte, 8)
+	payload := make([]byte, 8, 8+len(f.AdditionalDebugData))
 	binary.BigEndian.PutUint32(payload[0:4], f.LastStreamId)
 	binary.BigEndian.PutUint32(payload[4:8], uint32(f.ErrorCode))
-	return payload, nil
+	payload = append(payload, f.AdditionalDebugData...)
+	var result bytes.Buffer
+	result.Write(encodeHeader(f.Type(), f.StreamId, uint32(len(payload)), []Flag{}))
+	result.Write(payload)
+	return result.Bytes(), nil
 }
 
 func (f *GoAwayFrame) GetStreamId() uint32 {
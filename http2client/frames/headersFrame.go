@@ -1,7 +1,7 @@
 package frames
 
 import (
-	"bytes"
+	"encoding/binary"
 	"fmt"
 	"golang.org/x/net/http2/hpack"
 )
@@ -17,8 +17,15 @@ type HeadersFrame struct {
 	StreamId   uint32
 	EndStream  bool
 	EndHeaders bool
+	Padded     bool
+	PadLength  uint8 // Number of padding bytes, only used if Padded is true.
 	Priority   bool
-	Headers    []hpack.HeaderField
+	// The following fields are only used if Priority is true.
+	StreamDependency uint32
+	Exclusive        bool
+	Weight           uint8 // Priority weight minus one, i.e. 0 means weight 1 and 255 means weight 256.
+	Headers          []hpack.HeaderField
+	HpackInfo        *HpackInfo // Set by the decoder or by Encode() if enabled with EnableHpackInfo().
 }
 
 func NewHeadersFrame(streamId uint32, headers []hpack.HeaderField) *HeadersFrame {
@@ -32,43 +39,70 @@ func NewHeadersFrame(streamId uint32, headers []hpack.HeaderField) *HeadersFrame
 }
 
 // must be called after stripPadding()
-func stripPriority(payload []byte) ([]byte, error) {
-	if len(payload) <= 5 {
-		// TODO: trigger connection error
-		return nil, fmt.Errorf("Invalid HEADERS frame: Priority flag set, but stream dependency missing.")
+func stripPriority(payload []byte) ([]byte, uint32, bool, uint8, error) {
+	if len(payload) < 5 {
+		return nil, 0, false, 0, newConnectionError(FRAME_SIZE_ERROR, "Received %v frame with PRIORITY flag, but stream dependency missing.", HEADERS_TYPE)
 	}
-	return payload[5:], nil
+	exclusive := payload[0]&0x80 != 0
+	streamDependency := binary.BigEndian.Uint32(payload[0:4]) & 0x7FFFFFFF
+	weight := payload[4]
+	return payload[5:], streamDependency, exclusive, weight, nil
+}
+
+func encodePriority(streamDependency uint32, exclusive bool, weight uint8) []byte {
+	result := make([]byte, 5)
+	binary.BigEndian.PutUint32(result[0:4], streamDependency&0x7FFFFFFF)
+	if exclusive {
+		result[0] |= 0x80
+	}
+	result[4] = weight
+	return result
 }
 
 func DecodeHeadersFrame(flags byte, streamId uint32, payload []byte, context *DecodingContext) (Frame, error) {
+	if err := requireStreamId(HEADERS_TYPE, streamId); err != nil {
+		return nil, err
+	}
 	endStream := HEADERS_FLAG_END_STREAM.isSet(flags)
 	endHeaders := HEADERS_FLAG_END_HEADERS.isSet(flags)
 	padded := HEADERS_FLAG_PADDED.isSet(flags)
 	priority := HEADERS_FLAG_PRIORITY.isSet(flags)
+	var padLength, weight uint8
+	var streamDependency uint32
+	var exclusive bool
 	var err error
 	if padded {
-		payload, err = stripPadding(payload)
+		payload, padLength, err = stripPadding(HEADERS_TYPE, payload)
 		if err != nil {
 			return nil, err
 		}
 	}
 	if priority {
-		payload, err = stripPriority(payload) // TODO: Priority ignored.
-		// printBytes("Payload with stripped priority", payload)
+		payload, streamDependency, exclusive, weight, err = stripPriority(payload)
 		if err != nil {
 			return nil, err
 		}
 	}
-	headers, err := context.decoder.DecodeFull(payload)
+	headers, hpackInfo, err := context.decodeHeaderBlockFragment(payload, endHeaders)
 	if err != nil {
-		return nil, fmt.Errorf("Error decoding header fields: ", err.Error())
+		return nil, newConnectionError(COMPRESSION_ERROR, "Error decoding header fields: %v", err.Error())
+	}
+	if priority && streamDependency == streamId {
+		// Checked after decoding the header block, because the header block must be processed to keep the HPACK state in sync.
+		return nil, newStreamError(streamId, PROTOCOL_ERROR, "Received %v frame where stream %v depends on itself.", HEADERS_TYPE, streamId)
 	}
 	return &HeadersFrame{
-		StreamId:   streamId,
-		EndStream:  endStream,
-		EndHeaders: endHeaders,
-		Priority:   priority,
-		Headers:    headers,
+		StreamId:         streamId,
+		EndStream:        endStream,
+		EndHeaders:       endHeaders,
+		Padded:           padded,
+		PadLength:        padLength,
+		Priority:         priority,
+		StreamDependency: streamDependency,
+		Exclusive:        exclusive,
+		Weight:           weight,
+		Headers:          headers,
+		HpackInfo:        hpackInfo,
 	}, nil
 }
 
@@ -81,26 +115,37 @@ func (f *HeadersFrame) flags() []Flag {
 	if f.EndStream {
 		flags = append(flags, HEADERS_FLAG_END_STREAM)
 	}
-	if f.EndHeaders {
-		flags = append(flags, HEADERS_FLAG_END_HEADERS)
+	if f.Padded {
+		flags = append(flags, HEADERS_FLAG_PADDED)
+	}
+	if f.Priority {
+		flags = append(flags, HEADERS_FLAG_PRIORITY)
 	}
 	return flags
 }
 
+// Encode returns the HEADERS frame, followed by CONTINUATION frames if the header block
+// exceeds the max frame size of the EncodingContext. Padding and priority are only added to the HEADERS frame.
 func (f *HeadersFrame) Encode(context *EncodingContext) ([]byte, error) {
+	data, _, err := f.encodeFrames(context)
+	return data, err
+}
+
+func (f *HeadersFrame) encodeFrames(context *EncodingContext) ([]byte, []Frame, error) {
 	defer context.headerBlockBuffer.Reset()
-	for _, header := range f.Headers {
-		err := context.encoder.WriteField(header)
-		if err != nil {
-			return nil, fmt.Errorf("Failed to encode HEADER frame: %v", err)
-		}
+	headerBlock, err := context.encodeHeaderBlock(f.Headers)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to encode HEADER frame: %v", err)
+	}
+	var prefix, padding []byte
+	if f.Padded {
+		prefix, padding = makePadding(f.PadLength)
+	}
+	if f.Priority {
+		prefix = append(prefix, encodePriority(f.StreamDependency, f.Exclusive, f.Weight)...)
 	}
-	headerBlockFragment := context.headerBlockBuffer.Bytes()
-	length := uint32(len(headerBlockFragment))
-	var result bytes.Buffer
-	result.Write(encodeHeader(f.Type(), f.StreamId, length, f.flags()))
-	result.Write(headerBlockFragment)
-	return result.Bytes(), nil
+	data, encodedFrames := encodeHeaderBlockFrames(f, f.flags(), prefix, headerBlock, padding, f.EndHeaders, context)
+	return data, encodedFrames, nil
 }
 
 func (f *HeadersFrame) GetStreamId() uint32 {
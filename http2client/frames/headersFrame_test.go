@@ -56,14 +56,40 @@ func TestPadding(t *testing.T) {
 		if err != nil {
 			t.Error("Decoding error:", err.Error())
 		}
+		frame.Padded = true // to compare with result
+		frame.PadLength = uint8(i)
 		if !reflect.DeepEqual(frame, result) {
 			t.Error("Result does not equal expected frame.")
 		}
 	}
 }
 
+func TestEncodePadding(t *testing.T) {
+	frame := makeExampleFrame()
+	frame.Padded = true
+	frame.PadLength = 17
+	data, err := frame.Encode(NewEncodingContext())
+	if err != nil {
+		t.Error("Encoding error:", err.Error())
+	}
+	frameHeader := DecodeHeader(data[0:9])
+	if !HEADERS_FLAG_PADDED.isSet(frameHeader.Flags) {
+		t.Error("PADDED flag not set.")
+	}
+	if data[9] != 17 || !reflect.DeepEqual(data[len(data)-17:], make([]byte, 17)) {
+		t.Error("Expected Pad Length 17 followed by 17 zero bytes of padding.")
+	}
+	result, err := DecodeHeadersFrame(frameHeader.Flags, frameHeader.StreamId, data[9:], NewDecodingContext())
+	if err != nil {
+		t.Error("Decoding error:", err.Error())
+	}
+	if !reflect.DeepEqual(frame, result) {
+		t.Error("Result does not equal expected frame.")
+	}
+}
+
 func addPriority(data []byte) []byte {
-	// priority is currently ignored, so just pre-pend 5 zero bytes.
+	// pre-pend 5 zero bytes, i.e. stream dependency 0, not exclusive, weight 1.
 	result := make([]byte, len(data)+5)
 	copy(result[0:9], data[0:9])
 	HEADERS_FLAG_PRIORITY.set(&result[4])
@@ -97,6 +123,53 @@ func TestPriority(t *testing.T) {
 	}
 }
 
+func TestEncodePriority(t *testing.T) {
+	frame := makeExampleFrame()
+	frame.Priority = true
+	frame.StreamDependency = 7
+	frame.Exclusive = true
+	frame.Weight = 200
+	data, err := frame.Encode(NewEncodingContext())
+	if err != nil {
+		t.Error("Encoding error:", err.Error())
+	}
+	frameHeader := DecodeHeader(data[0:9])
+	if !HEADERS_FLAG_PRIORITY.isSet(frameHeader.Flags) {
+		t.Error("PRIORITY flag not set.")
+	}
+	if binary.BigEndian.Uint32(data[9:13]) != 0x80000007 || data[13] != 200 {
+		t.Error("Expected exclusive stream dependency 7 with weight 200.")
+	}
+	result, err := DecodeHeadersFrame(frameHeader.Flags, frameHeader.StreamId, data[9:], NewDecodingContext())
+	if err != nil {
+		t.Error("Decoding error:", err.Error())
+	}
+	if !reflect.DeepEqual(frame, result) {
+		t.Error("Result does not equal expected frame.")
+	}
+}
+
+func TestEncodePaddingAndPriority(t *testing.T) {
+	frame := makeExampleFrame()
+	frame.Padded = true
+	frame.PadLength = 3
+	frame.Priority = true
+	frame.StreamDependency = 5
+	frame.Weight = 15
+	data, err := frame.Encode(NewEncodingContext())
+	if err != nil {
+		t.Error("Encoding error:", err.Error())
+	}
+	frameHeader := DecodeHeader(data[0:9])
+	result, err := DecodeHeadersFrame(frameHeader.Flags, frameHeader.StreamId, data[9:], NewDecodingContext())
+	if err != nil {
+		t.Error("Decoding error:", err.Error())
+	}
+	if !reflect.DeepEqual(frame, result) {
+		t.Error("Result does not equal expected frame.")
+	}
+}
+
 func TestPaddingAndPriority(t *testing.T) {
 	frame := makeExampleFrame()
 	data, err := frame.Encode(NewEncodingContext())
@@ -111,6 +184,8 @@ func TestPaddingAndPriority(t *testing.T) {
 		t.Error("Decoding error:", err.Error())
 	}
 	frame.Priority = true // to compare with result
+	frame.Padded = true
+	frame.PadLength = 7
 	if !reflect.DeepEqual(frame, result) {
 		t.Error("Result does not equal expected frame.")
 	}
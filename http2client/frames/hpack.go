@@ -0,0 +1,119 @@
+package frames
+
+import (
+	"fmt"
+	"golang.org/x/net/http2/hpack"
+)
+
+// The hpack package does not provide access to the representation of the header fields,
+// so this file implements the parts of the HPACK wire format needed for debugging, see RFC 7541 section 6.
+
+// removeHuffmanEncoding re-encodes all Huffman coded string literals in an HPACK encoded byte sequence
+// as raw octets. The dynamic table is not affected, because it contains the decoded header fields.
+func removeHuffmanEncoding(data []byte) ([]byte, error) {
+	result := make([]byte, 0, len(data))
+	for len(data) > 0 {
+		var (
+			prefixBits byte
+			index      uint64
+			rest       []byte
+			err        error
+		)
+		switch {
+		case data[0]&0x80 != 0: // Indexed Header Field
+			prefixBits = 7
+		case data[0]&0xe0 == 0x20: // Dynamic Table Size Update
+			prefixBits = 5
+		case data[0]&0xc0 == 0x40: // Literal Header Field with Incremental Indexing
+			prefixBits = 6
+		default: // Literal Header Field without Indexing or Never Indexed
+			prefixBits = 4
+		}
+		index, rest, err = readVarInt(prefixBits, data)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, data[:len(data)-len(rest)]...)
+		data = rest
+		if prefixBits == 7 || prefixBits == 5 {
+			continue // no string literals
+		}
+		nStrings := 1 // value
+		if index == 0 {
+			nStrings = 2 // name and value
+		}
+		for i := 0; i < nStrings; i++ {
+			var s string
+			s, _, data, err = readString(data)
+			if err != nil {
+				return nil, err
+			}
+			result = appendVarInt(result, 7, uint64(len(s)))
+			result = append(result, s...)
+		}
+	}
+	return result, nil
+}
+
+// readVarInt reads an integer with an n-bit prefix, see RFC 7541 section 5.1.
+func readVarInt(n byte, data []byte) (uint64, []byte, error) {
+	if len(data) == 0 {
+		return 0, nil, fmt.Errorf("Invalid HPACK integer: no data.")
+	}
+	i := uint64(data[0] & (1<<n - 1))
+	if i < 1<<n-1 {
+		return i, data[1:], nil
+	}
+	var m uint
+	for pos := 1; pos < len(data); pos++ {
+		b := data[pos]
+		i += uint64(b&0x7f) << m
+		if b&0x80 == 0 {
+			return i, data[pos+1:], nil
+		}
+		m += 7
+		if m >= 63 {
+			return 0, nil, fmt.Errorf("Invalid HPACK integer: too large.")
+		}
+	}
+	return 0, nil, fmt.Errorf("Invalid HPACK integer: truncated.")
+}
+
+// appendVarInt appends an integer with an n-bit prefix, see RFC 7541 section 5.1.
+// The caller sets the remaining bits of the first byte.
+func appendVarInt(dst []byte, n byte, i uint64) []byte {
+	k := uint64(1<<n - 1)
+	if i < k {
+		return append(dst, byte(i))
+	}
+	dst = append(dst, byte(k))
+	i -= k
+	for ; i >= 128; i >>= 7 {
+		dst = append(dst, byte(0x80|(i&0x7f)))
+	}
+	return append(dst, byte(i))
+}
+
+// readString reads a string literal, see RFC 7541 section 5.2.
+// The second return value is true if the string was Huffman encoded.
+func readString(data []byte) (string, bool, []byte, error) {
+	if len(data) == 0 {
+		return "", false, nil, fmt.Errorf("Invalid HPACK string: no data.")
+	}
+	isHuffman := data[0]&0x80 != 0
+	length, rest, err := readVarInt(7, data)
+	if err != nil {
+		return "", false, nil, err
+	}
+	if uint64(len(rest)) < length {
+		return "", false, nil, fmt.Errorf("Invalid HPACK string: truncated.")
+	}
+	if !isHuffman {
+		return string(rest[:length]), false, rest[length:], nil
+	}
+	s, err := hpack.HuffmanDecodeToString(rest[:length])
+	if err != nil {
+		return "", false, nil, err
+	}
+	return s, true, rest[length:], nil
+}
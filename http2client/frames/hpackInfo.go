@@ -0,0 +1,197 @@
+package frames
+
+import (
+	"golang.org/x/net/http2/hpack"
+)
+
+type HpackRepresentation byte
+
+const (
+	HPACK_INDEXED              HpackRepresentation = iota // RFC 7541 section 6.1
+	HPACK_INCREMENTAL_INDEXING                            // RFC 7541 section 6.2.1
+	HPACK_WITHOUT_INDEXING                                // RFC 7541 section 6.2.2
+	HPACK_NEVER_INDEXED                                   // RFC 7541 section 6.2.3
+	HPACK_TABLE_SIZE_UPDATE                               // RFC 7541 section 6.3
+)
+
+const hpackStaticTableLength = 61 // RFC 7541 appendix A
+
+// HpackField describes how a single header field was represented in an HPACK encoded header block.
+type HpackField struct {
+	Representation HpackRepresentation
+	Index          uint64              // Index of the header field if HPACK_INDEXED, index of the name for literals, 0 for literals with a new name.
+	HuffmanName    bool                // Only used for literals with a new name.
+	HuffmanValue   bool                // Only used for literals.
+	Header         hpack.HeaderField   // Empty for HPACK_TABLE_SIZE_UPDATE.
+	TableSize      uint32              // Only used for HPACK_TABLE_SIZE_UPDATE.
+	Evicted        []hpack.HeaderField // Entries evicted from the dynamic table, oldest first.
+	EncodedLength  int                 // Number of bytes of the representation.
+}
+
+// HpackInfo describes the HPACK encoding of a header block, or of the fragment of a header block
+// in a single HEADERS, PUSH_PROMISE, or CONTINUATION frame.
+// It is only available if enabled with EnableHpackInfo() on the DecodingContext or EncodingContext.
+type HpackInfo struct {
+	Fields        []*HpackField
+	EncodedLength int // Number of bytes of the HPACK encoded header block.
+	DecodedLength int // Number of bytes of the names and values of the decoded header fields.
+}
+
+func (f *HpackField) IsStaticTableIndex() bool {
+	return f.Index <= hpackStaticTableLength
+}
+
+// hpackTracker mirrors the dynamic table of an HPACK encoder or decoder, see RFC 7541 section 4.
+//
+// The hpack package neither exposes the representation of header fields nor the dynamic table,
+// so the tracker parses the header block again. The decoded header fields are taken from the hpack package,
+// because each representation except a dynamic table size update results in exactly one header field.
+type hpackTracker struct {
+	entries []hpack.HeaderField // oldest first
+	size    uint32
+	maxSize uint32
+	pending []byte // incomplete representation at the end of the previous fragment
+}
+
+func newHpackTracker() *hpackTracker {
+	return &hpackTracker{
+		maxSize: 4096, // Initial value of SETTINGS_HEADER_TABLE_SIZE.
+	}
+}
+
+// analyze describes the representations in the fragment. headers must contain the header fields
+// completed by this fragment, as returned by the hpack package.
+func (t *hpackTracker) analyze(fragment []byte, headers []hpack.HeaderField, endHeaders bool) *HpackInfo {
+	result := &HpackInfo{
+		Fields:        make([]*HpackField, 0, len(headers)),
+		EncodedLength: len(fragment),
+	}
+	data := append(t.pending, fragment...)
+	for len(data) > 0 {
+		field, rest, err := parseRepresentation(data)
+		if err != nil {
+			break // incomplete representation, will be completed by the next CONTINUATION frame
+		}
+		field.EncodedLength = len(data) - len(rest)
+		data = rest
+		if field.Representation == HPACK_TABLE_SIZE_UPDATE {
+			t.maxSize = field.TableSize
+			field.Evicted = t.evict(0)
+		} else {
+			if len(headers) > 0 {
+				field.Header = headers[0]
+				headers = headers[1:]
+			}
+			result.DecodedLength += len(field.Header.Name) + len(field.Header.Value)
+			if field.Representation == HPACK_INCREMENTAL_INDEXING {
+				field.Evicted = t.add(field.Header)
+			}
+		}
+		result.Fields = append(result.Fields, field)
+	}
+	t.pending = nil
+	if !endHeaders && len(data) > 0 {
+		t.pending = append([]byte{}, data...)
+	}
+	return result
+}
+
+// add inserts a new entry into the dynamic table and returns the evicted entries, see RFC 7541 section 4.4.
+func (t *hpackTracker) add(header hpack.HeaderField) []hpack.HeaderField {
+	entrySize := header.Size()
+	if entrySize > t.maxSize {
+		return t.evict(t.maxSize) // An entry larger than the table empties the table and is not added.
+	}
+	evicted := t.evict(entrySize)
+	t.entries = append(t.entries, hpack.HeaderField{Name: header.Name, Value: header.Value})
+	t.size += entrySize
+	return evicted
+}
+
+// evict removes the oldest entries until there is room for an entry of size required.
+func (t *hpackTracker) evict(required uint32) []hpack.HeaderField {
+	var evicted []hpack.HeaderField
+	for len(t.entries) > 0 && t.size+required > t.maxSize {
+		evicted = append(evicted, t.entries[0])
+		t.size -= t.entries[0].Size()
+		t.entries = t.entries[1:]
+	}
+	return evicted
+}
+
+func parseRepresentation(data []byte) (*HpackField, []byte, error) {
+	var (
+		field      = &HpackField{}
+		prefixBits byte
+	)
+	switch {
+	case data[0]&0x80 != 0:
+		field.Representation = HPACK_INDEXED
+		prefixBits = 7
+	case data[0]&0xe0 == 0x20:
+		field.Representation = HPACK_TABLE_SIZE_UPDATE
+		prefixBits = 5
+	case data[0]&0xc0 == 0x40:
+		field.Representation = HPACK_INCREMENTAL_INDEXING
+		prefixBits = 6
+	case data[0]&0xf0 == 0x10:
+		field.Representation = HPACK_NEVER_INDEXED
+		prefixBits = 4
+	default:
+		field.Representation = HPACK_WITHOUT_INDEXING
+		prefixBits = 4
+	}
+	index, rest, err := readVarInt(prefixBits, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch field.Representation {
+	case HPACK_TABLE_SIZE_UPDATE:
+		field.TableSize = uint32(index)
+		return field, rest, nil
+	case HPACK_INDEXED:
+		field.Index = index
+		return field, rest, nil
+	}
+	field.Index = index
+	if index == 0 {
+		_, field.HuffmanName, rest, err = readString(rest)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	_, field.HuffmanValue, rest, err = readString(rest)
+	if err != nil {
+		return nil, nil, err
+	}
+	return field, rest, nil
+}
+
+// appendHpackInfo is used when CONTINUATION frames are merged into the preceding HEADERS or PUSH_PROMISE frame.
+func appendHpackInfo(info *HpackInfo, continuation *HpackInfo) *HpackInfo {
+	if info == nil || continuation == nil {
+		return info
+	}
+	return &HpackInfo{
+		Fields:        append(info.Fields, continuation.Fields...),
+		EncodedLength: info.EncodedLength + continuation.EncodedLength,
+		DecodedLength: info.DecodedLength + continuation.DecodedLength,
+	}
+}
+
+func (r HpackRepresentation) String() string {
+	switch r {
+	case HPACK_INDEXED:
+		return "indexed"
+	case HPACK_INCREMENTAL_INDEXING:
+		return "literal with incremental indexing"
+	case HPACK_WITHOUT_INDEXING:
+		return "literal without indexing"
+	case HPACK_NEVER_INDEXED:
+		return "literal never indexed"
+	case HPACK_TABLE_SIZE_UPDATE:
+		return "dynamic table size update"
+	default:
+		return "unknown representation"
+	}
+}
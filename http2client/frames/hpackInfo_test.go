@@ -0,0 +1,119 @@
+package frames
+
+import (
+	"bytes"
+	"golang.org/x/net/http2/hpack"
+	"reflect"
+	"testing"
+)
+
+func encodeAndDecodeWithHpackInfo(t *testing.T, frame *HeadersFrame, encodingContext *EncodingContext, decodingContext *DecodingContext) *HeadersFrame {
+	data, err := frame.Encode(encodingContext)
+	if err != nil {
+		t.Fatal("Encoding error:", err.Error())
+	}
+	reader := NewReader(bytes.NewReader(data), decodingContext)
+	reader.ReassembleHeaderBlocks(true)
+	result, err := reader.ReadFrame()
+	if err != nil {
+		t.Fatal("Decoding error:", err.Error())
+	}
+	if !reflect.DeepEqual(frame.HpackInfo, result.(*HeadersFrame).HpackInfo) {
+		t.Error("HpackInfo of encoded frame does not equal HpackInfo of decoded frame.")
+	}
+	return result.(*HeadersFrame)
+}
+
+func assertHpackField(t *testing.T, field *HpackField, representation HpackRepresentation, index uint64) {
+	if field.Representation != representation || field.Index != index {
+		t.Errorf("Expected %v with index %v, but got %v with index %v.", representation, index, field.Representation, field.Index)
+	}
+}
+
+func TestHpackInfo(t *testing.T) {
+	encodingContext := NewEncodingContext()
+	encodingContext.EnableHpackInfo()
+	decodingContext := NewDecodingContext()
+	decodingContext.EnableHpackInfo()
+	frame := NewHeadersFrame(1, []hpack.HeaderField{
+		hpack.HeaderField{Name: ":method", Value: "GET"},
+		hpack.HeaderField{Name: "x-custom-header", Value: "custom value"},
+		hpack.HeaderField{Name: "authorization", Value: "Basic dXNlcjpwYXNz"},
+	})
+	info := encodeAndDecodeWithHpackInfo(t, frame, encodingContext, decodingContext).HpackInfo
+	if info == nil || len(info.Fields) != 3 {
+		t.Fatalf("Expected HpackInfo with 3 fields, but got %v.", info)
+	}
+	assertHpackField(t, info.Fields[0], HPACK_INDEXED, 2)
+	assertHpackField(t, info.Fields[1], HPACK_INCREMENTAL_INDEXING, 0)
+	assertHpackField(t, info.Fields[2], HPACK_NEVER_INDEXED, 23)
+	if !info.Fields[1].HuffmanName || !info.Fields[1].HuffmanValue {
+		t.Error("Expected Huffman encoded name and value.")
+	}
+	if info.DecodedLength != len(":methodGETx-custom-headercustom valueauthorizationBasic dXNlcjpwYXNz") {
+		t.Errorf("Unexpected decoded length %v.", info.DecodedLength)
+	}
+	encodedLength := 0
+	for _, field := range info.Fields {
+		encodedLength += field.EncodedLength
+	}
+	if encodedLength != info.EncodedLength {
+		t.Errorf("Encoded length of fields is %v, but encoded length of header block is %v.", encodedLength, info.EncodedLength)
+	}
+	frame = NewHeadersFrame(3, []hpack.HeaderField{
+		hpack.HeaderField{Name: "x-custom-header", Value: "custom value"},
+	})
+	info = encodeAndDecodeWithHpackInfo(t, frame, encodingContext, decodingContext).HpackInfo
+	assertHpackField(t, info.Fields[0], HPACK_INDEXED, 62)
+	if info.Fields[0].IsStaticTableIndex() {
+		t.Error("Expected index 62 to refer to the dynamic table.")
+	}
+}
+
+func TestHpackInfoEviction(t *testing.T) {
+	encodingContext := NewEncodingContext()
+	encodingContext.EnableHpackInfo()
+	encodingContext.SetHeaderTableSize(100)
+	decodingContext := NewDecodingContext()
+	decodingContext.EnableHpackInfo()
+	frame := NewHeadersFrame(1, []hpack.HeaderField{
+		hpack.HeaderField{Name: "x-a", Value: "aaaa"}, // size 39
+		hpack.HeaderField{Name: "x-b", Value: "bbbb"},
+		hpack.HeaderField{Name: "x-c", Value: "cccc"},
+	})
+	info := encodeAndDecodeWithHpackInfo(t, frame, encodingContext, decodingContext).HpackInfo
+	if len(info.Fields) != 4 {
+		t.Fatalf("Expected dynamic table size update and 3 header fields, but got %v fields.", len(info.Fields))
+	}
+	if info.Fields[0].Representation != HPACK_TABLE_SIZE_UPDATE || info.Fields[0].TableSize != 100 {
+		t.Errorf("Expected dynamic table size update to 100.")
+	}
+	if len(info.Fields[2].Evicted) != 0 {
+		t.Errorf("Expected no eviction, but got %v.", info.Fields[2].Evicted)
+	}
+	expected := []hpack.HeaderField{hpack.HeaderField{Name: "x-a", Value: "aaaa"}}
+	if !reflect.DeepEqual(info.Fields[3].Evicted, expected) {
+		t.Errorf("Expected %v to be evicted, but got %v.", expected, info.Fields[3].Evicted)
+	}
+}
+
+func TestHpackInfoWithContinuation(t *testing.T) {
+	encodingContext := NewEncodingContext()
+	encodingContext.EnableHpackInfo()
+	encodingContext.SetMaxFrameSize(16)
+	decodingContext := NewDecodingContext()
+	decodingContext.EnableHpackInfo()
+	frame := NewHeadersFrame(1, []hpack.HeaderField{
+		hpack.HeaderField{Name: ":path", Value: "/some/long/path/that/does/not/fit/into/a/single/frame"},
+		hpack.HeaderField{Name: "x-custom-header", Value: "custom value"},
+	})
+	info := encodeAndDecodeWithHpackInfo(t, frame, encodingContext, decodingContext).HpackInfo
+	if len(info.Fields) != 2 {
+		t.Fatalf("Expected 2 fields, but got %v.", len(info.Fields))
+	}
+	assertHpackField(t, info.Fields[0], HPACK_INCREMENTAL_INDEXING, 4)
+	assertHpackField(t, info.Fields[1], HPACK_INCREMENTAL_INDEXING, 0)
+	if info.Fields[0].Header.Value != frame.Headers[0].Value {
+		t.Errorf("Expected %v, but got %v.", frame.Headers[0].Value, info.Fields[0].Header.Value)
+	}
+}
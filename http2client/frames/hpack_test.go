@@ -0,0 +1,74 @@
+package frames
+
+import (
+	"bytes"
+	"golang.org/x/net/http2/hpack"
+	"reflect"
+	"testing"
+)
+
+func TestHuffmanOff(t *testing.T) {
+	headers := []hpack.HeaderField{
+		hpack.HeaderField{Name: ":path", Value: "/some/long/path/that/is/huffman/encoded"},
+		hpack.HeaderField{Name: "x-custom-header", Value: "custom value"},
+	}
+	context := NewEncodingContext()
+	context.SetHuffman(false)
+	encoded, err := context.encodeHeaderBlock(headers)
+	if err != nil {
+		t.Fatal("Encoding error:", err.Error())
+	}
+	headerBlock := encoded.data
+	for _, s := range []string{"/some/long/path/that/is/huffman/encoded", "x-custom-header", "custom value"} {
+		if !bytes.Contains(headerBlock, []byte(s)) {
+			t.Errorf("Expected %v to be encoded without Huffman.", s)
+		}
+	}
+	decoded, err := hpack.NewDecoder(4096, nil).DecodeFull(headerBlock)
+	if err != nil {
+		t.Fatal("Decoding error:", err.Error())
+	}
+	if !reflect.DeepEqual(decoded, headers) {
+		t.Error("Result does not equal expected headers.")
+	}
+}
+
+func TestSensitiveHeader(t *testing.T) {
+	context := NewEncodingContext()
+	encoded, err := context.encodeHeaderBlock([]hpack.HeaderField{
+		hpack.HeaderField{Name: "authorization", Value: "Basic dXNlcjpwYXNz"},
+	})
+	if err != nil {
+		t.Fatal("Encoding error:", err.Error())
+	}
+	headerBlock := encoded.data
+	if headerBlock[0]&0xf0 != 0x10 {
+		t.Errorf("Expected never indexed representation, but got 0x%02X.", headerBlock[0])
+	}
+	decoded, err := hpack.NewDecoder(4096, nil).DecodeFull(headerBlock)
+	if err != nil {
+		t.Fatal("Decoding error:", err.Error())
+	}
+	if len(decoded) != 1 || !decoded[0].Sensitive {
+		t.Error("Expected decoded header to be sensitive.")
+	}
+}
+
+func TestHeaderTableSizeUpdate(t *testing.T) {
+	context := NewEncodingContext()
+	context.SetHeaderTableSize(256)
+	encoded, err := context.encodeHeaderBlock([]hpack.HeaderField{
+		hpack.HeaderField{Name: ":method", Value: "GET"},
+	})
+	if err != nil {
+		t.Fatal("Encoding error:", err.Error())
+	}
+	headerBlock := encoded.data
+	if headerBlock[0]&0xe0 != 0x20 {
+		t.Fatalf("Expected dynamic table size update, but got 0x%02X.", headerBlock[0])
+	}
+	size, _, err := readVarInt(5, headerBlock)
+	if err != nil || size != 256 {
+		t.Errorf("Expected dynamic table size update to 256, but got %v.", size)
+	}
+}
@@ -0,0 +1,57 @@
+package frames
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// OriginFrame lists the origins the server is authoritative for, see RFC 8336.
+type OriginFrame struct {
+	StreamId uint32
+	Origins  []string
+}
+
+func NewOriginFrame(streamId uint32, origins []string) *OriginFrame {
+	return &OriginFrame{
+		StreamId: streamId,
+		Origins:  origins,
+	}
+}
+
+func DecodeOriginFrame(flags byte, streamId uint32, payload []byte, context *DecodingContext) (Frame, error) {
+	origins := make([]string, 0)
+	for len(payload) > 0 {
+		if len(payload) < 2 {
+			return nil, newConnectionError(FRAME_SIZE_ERROR, "Received %v frame with truncated Origin-Entry.", ORIGIN_TYPE)
+		}
+		originLen := int(binary.BigEndian.Uint16(payload[0:2]))
+		if len(payload) < 2+originLen {
+			return nil, newConnectionError(FRAME_SIZE_ERROR, "Received %v frame with origin length %v, but only %v bytes left.", ORIGIN_TYPE, originLen, len(payload)-2)
+		}
+		origins = append(origins, string(payload[2:2+originLen]))
+		payload = payload[2+originLen:]
+	}
+	return NewOriginFrame(streamId, origins), nil
+}
+
+func (f *OriginFrame) Type() Type {
+	return ORIGIN_TYPE
+}
+
+func (f *OriginFrame) Encode(context *EncodingContext) ([]byte, error) {
+	var payload bytes.Buffer
+	originLen := make([]byte, 2)
+	for _, origin := range f.Origins {
+		binary.BigEndian.PutUint16(originLen, uint16(len(origin)))
+		payload.Write(originLen)
+		payload.WriteString(origin)
+	}
+	var result bytes.Buffer
+	result.Write(encodeHeader(f.Type(), f.StreamId, uint32(payload.Len()), []Flag{}))
+	result.Write(payload.Bytes())
+	return result.Bytes(), nil
+}
+
+func (f *OriginFrame) GetStreamId() uint32 {
+	return f.StreamId
+}
@@ -3,7 +3,6 @@ package frames
 import (
 	"bytes"
 	"encoding/binary"
-	"fmt"
 )
 
 const (
@@ -25,11 +24,11 @@ func NewPingFrame(streamId uint32, payload uint64, ack bool) *PingFrame {
 }
 
 func DecodePingFrame(flags byte, streamId uint32, payload []byte, context *DecodingContext) (Frame, error) {
-	if streamId != 0 {
-		return nil, fmt.Errorf("Connection error: Received ping frame with stream id %v.", streamId)
+	if err := requireStreamIdZero(PING_TYPE, streamId); err != nil {
+		return nil, err
 	}
 	if len(payload) != 8 {
-		return nil, fmt.Errorf("Connection error: Received ping frame with %v bytes payload.", len(payload))
+		return nil, newConnectionError(FRAME_SIZE_ERROR, "Received %v frame of length %v.", PING_TYPE, len(payload))
 	}
 	return NewPingFrame(streamId, binary.BigEndian.Uint64(payload), ACK.isSet(flags)), nil
 }
@@ -1,8 +1,8 @@
 package frames
 
 import (
+	"bytes"
 	"encoding/binary"
-	"fmt"
 )
 
 type PriorityFrame struct {
@@ -22,12 +22,18 @@ func NewPriorityFrame(streamId uint32, streamDependencyId uint32, weight uint8,
 }
 
 func DecodePriorityFrame(flags byte, streamId uint32, payload []byte, context *DecodingContext) (Frame, error) {
+	if err := requireStreamId(PRIORITY_TYPE, streamId); err != nil {
+		return nil, err
+	}
 	if len(payload) != 5 {
-		return nil, fmt.Errorf("FRAME_SIZE_ERROR: Received PRIORITY frame of length %v", len(payload))
+		return nil, newStreamError(streamId, FRAME_SIZE_ERROR, "Received %v frame of length %v.", PRIORITY_TYPE, len(payload))
 	}
 	streamDependencyId := uint32_ignoreFirstBit(payload[0:4])
 	weight := payload[4]
-	exclusive := payload[0]&0x80 == 1
+	exclusive := payload[0]&0x80 != 0
+	if streamDependencyId == streamId {
+		return nil, newStreamError(streamId, PROTOCOL_ERROR, "Received %v frame where stream %v depends on itself.", PRIORITY_TYPE, streamId)
+	}
 	return NewPriorityFrame(streamId, streamDependencyId, weight, exclusive), nil
 }
 
@@ -42,7 +48,10 @@ func (f *PriorityFrame) Encode(context *EncodingContext) ([]byte, error) {
 	if f.Exclusive {
 		payload[0] |= 0x80
 	}
-	return payload, nil
+	var result bytes.Buffer
+	result.Write(encodeHeader(f.Type(), f.StreamId, uint32(len(payload)), []Flag{}))
+	result.Write(payload)
+	return result.Bytes(), nil
 }
 
 func (f *PriorityFrame) GetStreamId() uint32 {
@@ -1,7 +1,6 @@
 package frames
 
 import (
-	"bytes"
 	"encoding/binary"
 	"fmt"
 	"golang.org/x/net/http2/hpack"
@@ -15,8 +14,11 @@ const (
 type PushPromiseFrame struct {
 	StreamId         uint32
 	EndHeaders       bool
+	Padded           bool
+	PadLength        uint8 // Number of padding bytes, only used if Padded is true.
 	PromisedStreamId uint32
 	Headers          []hpack.HeaderField
+	HpackInfo        *HpackInfo // Set by the decoder or by Encode() if enabled with EnableHpackInfo().
 }
 
 func NewPushPromiseFrame(streamId uint32, promisedStreamId uint32, headers []hpack.HeaderField) *PushPromiseFrame {
@@ -29,25 +31,35 @@ func NewPushPromiseFrame(streamId uint32, promisedStreamId uint32, headers []hpa
 }
 
 func DecodePushPromiseFrame(flags byte, streamId uint32, payload []byte, context *DecodingContext) (Frame, error) {
+	if err := requireStreamId(PUSH_PROMISE_TYPE, streamId); err != nil {
+		return nil, err
+	}
 	endHeaders := PUSH_PROMISE_FLAG_END_HEADERS.isSet(flags)
 	padded := PUSH_PROMISE_FLAG_PADDED.isSet(flags)
+	var padLength uint8
 	var err error
 	if padded {
-		payload, err = stripPadding(payload)
+		payload, padLength, err = stripPadding(PUSH_PROMISE_TYPE, payload)
 		if err != nil {
 			return nil, err
 		}
 	}
+	if len(payload) < 4 {
+		return nil, newConnectionError(FRAME_SIZE_ERROR, "Received %v frame without promised stream id.", PUSH_PROMISE_TYPE)
+	}
 	promisedStreamId := uint32_ignoreFirstBit(payload[0:4])
-	headers, err := context.decoder.DecodeFull(payload[4:])
+	headers, hpackInfo, err := context.decodeHeaderBlockFragment(payload[4:], endHeaders)
 	if err != nil {
-		return nil, fmt.Errorf("Error decoding header fields: ", err.Error())
+		return nil, newConnectionError(COMPRESSION_ERROR, "Error decoding header fields: %v", err.Error())
 	}
 	return &PushPromiseFrame{
 		StreamId:         streamId,
 		PromisedStreamId: promisedStreamId,
 		EndHeaders:       endHeaders,
+		Padded:           padded,
+		PadLength:        padLength,
 		Headers:          headers,
+		HpackInfo:        hpackInfo,
 	}, nil
 }
 
@@ -55,31 +67,30 @@ func (f *PushPromiseFrame) Type() Type {
 	return PUSH_PROMISE_TYPE
 }
 
-func (f *PushPromiseFrame) flags() []Flag {
-	flags := make([]Flag, 0)
-	if f.EndHeaders {
-		flags = append(flags, HEADERS_FLAG_END_HEADERS)
-	}
-	return flags
+// Encode returns the PUSH_PROMISE frame, followed by CONTINUATION frames if the header block
+// exceeds the max frame size of the EncodingContext. Padding is only added to the PUSH_PROMISE frame.
+func (f *PushPromiseFrame) Encode(context *EncodingContext) ([]byte, error) {
+	data, _, err := f.encodeFrames(context)
+	return data, err
 }
 
-func (f *PushPromiseFrame) Encode(context *EncodingContext) ([]byte, error) {
+func (f *PushPromiseFrame) encodeFrames(context *EncodingContext) ([]byte, []Frame, error) {
 	defer context.headerBlockBuffer.Reset()
-	for _, header := range f.Headers {
-		err := context.encoder.WriteField(header)
-		if err != nil {
-			return nil, fmt.Errorf("Failed to encode HEADER frame: %v", err)
-		}
+	headerBlock, err := context.encodeHeaderBlock(f.Headers)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to encode HEADER frame: %v", err)
+	}
+	flags := make([]Flag, 0)
+	var prefix, padding []byte
+	if f.Padded {
+		flags = append(flags, PUSH_PROMISE_FLAG_PADDED)
+		prefix, padding = makePadding(f.PadLength)
 	}
 	promisedStreamId := make([]byte, 4)
 	binary.BigEndian.PutUint32(promisedStreamId, f.PromisedStreamId)
-	headerBlockFragment := context.headerBlockBuffer.Bytes()
-	length := uint32(len(headerBlockFragment)) + uint32(len(promisedStreamId))
-	var result bytes.Buffer
-	result.Write(encodeHeader(f.Type(), f.StreamId, length, f.flags()))
-	result.Write(promisedStreamId)
-	result.Write(headerBlockFragment)
-	return result.Bytes(), nil
+	prefix = append(prefix, promisedStreamId...)
+	data, encodedFrames := encodeHeaderBlockFrames(f, flags, prefix, headerBlock, padding, f.EndHeaders, context)
+	return data, encodedFrames, nil
 }
 
 func (f *PushPromiseFrame) GetStreamId() uint32 {
@@ -0,0 +1,136 @@
+package frames
+
+import (
+	"io"
+	"io/ioutil"
+)
+
+// Reader reads HTTP/2 frames from an io.Reader.
+//
+// A Reader is not safe for concurrent use. The DecodingContext must not be shared with other Readers,
+// because it contains the HPACK decoding state of the connection.
+type Reader struct {
+	in                     io.Reader
+	context                *DecodingContext
+	capture                io.Writer
+	filter                 func(Frame) Frame
+	reassembleHeaderBlocks bool
+}
+
+func NewReader(in io.Reader, context *DecodingContext) *Reader {
+	return &Reader{
+		in:      in,
+		context: context,
+	}
+}
+
+// ReassembleHeaderBlocks configures whether CONTINUATION frames are merged into the preceding
+// HEADERS or PUSH_PROMISE frame. If enabled, ReadFrame never returns a ContinuationFrame,
+// and HEADERS and PUSH_PROMISE frames always have the END_HEADERS flag set.
+func (r *Reader) ReassembleHeaderBlocks(enabled bool) {
+	r.reassembleHeaderBlocks = enabled
+}
+
+// FilterFrames configures a function that is called with each frame before CONTINUATION frames are merged,
+// so that the filter sees the frames as they were received. The frame returned by the filter is used instead.
+// Use nil to disable filtering.
+func (r *Reader) FilterFrames(filter func(Frame) Frame) {
+	r.filter = filter
+}
+
+// CaptureRawBytes configures an io.Writer receiving a copy of all bytes read.
+// Use nil to disable capturing.
+func (r *Reader) CaptureRawBytes(capture io.Writer) {
+	r.capture = capture
+}
+
+// ReadFrame reads the next frame.
+//
+// If the frame length exceeds the max frame size of the DecodingContext, the payload is skipped
+// without allocating memory for it, and a FRAME_SIZE_ERROR connection error is returned,
+// see RFC 7540 section 4.2.
+//
+// Frames violating RFC 7540 result in a *FrameError. As the frame was read completely,
+// it is possible to continue reading frames after a *FrameError.
+func (r *Reader) ReadFrame() (Frame, error) {
+	frame, err := r.readSingleFrame()
+	if err != nil || !r.reassembleHeaderBlocks {
+		return frame, err
+	}
+	if frame.Type() == CONTINUATION_TYPE {
+		return nil, newConnectionError(PROTOCOL_ERROR, "Received %v frame for stream %v without preceding %v or %v frame.", frame.Type(), frame.GetStreamId(), HEADERS_TYPE, PUSH_PROMISE_TYPE)
+	}
+	for !hasEndHeaders(frame) {
+		next, err := r.readSingleFrame()
+		if err != nil {
+			return nil, err
+		}
+		continuation, isContinuation := next.(*ContinuationFrame)
+		if !isContinuation || continuation.StreamId != frame.GetStreamId() {
+			// The header block must be transmitted as a contiguous sequence of frames,
+			// with no interleaved frames of any other type or from any other stream (RFC 7540 section 6.10).
+			return nil, newConnectionError(PROTOCOL_ERROR, "Received %v frame for stream %v while waiting for %v frame for stream %v.", next.Type(), next.GetStreamId(), CONTINUATION_TYPE, frame.GetStreamId())
+		}
+		appendContinuation(frame, continuation)
+	}
+	return frame, nil
+}
+
+func (r *Reader) readSingleFrame() (Frame, error) {
+	headerData := make([]byte, 9) // Frame starts with a 9 Bytes header
+	_, err := io.ReadFull(r.in, headerData)
+	if err != nil {
+		return nil, err
+	}
+	r.captureRawBytes(headerData)
+	header := DecodeHeader(headerData)
+	if header.Length > r.context.MaxFrameSize() {
+		_, err = io.CopyN(ioutil.Discard, r.in, int64(header.Length))
+		if err != nil {
+			return nil, err
+		}
+		return nil, newConnectionError(FRAME_SIZE_ERROR, "Received %v frame of length %v, but max frame size is %v.", header.HeaderType, header.Length, r.context.MaxFrameSize())
+	}
+	payload := make([]byte, header.Length)
+	_, err = io.ReadFull(r.in, payload)
+	if err != nil {
+		return nil, err
+	}
+	r.captureRawBytes(payload)
+	frame, err := FindDecoder(header.HeaderType)(header.Flags, header.StreamId, payload, r.context)
+	if err != nil || r.filter == nil {
+		return frame, err
+	}
+	return r.filter(frame), nil
+}
+
+func (r *Reader) captureRawBytes(data []byte) {
+	if r.capture != nil {
+		r.capture.Write(data)
+	}
+}
+
+// Frames other than HEADERS and PUSH_PROMISE are never followed by CONTINUATION frames.
+func hasEndHeaders(frame Frame) bool {
+	switch frame := frame.(type) {
+	case *HeadersFrame:
+		return frame.EndHeaders
+	case *PushPromiseFrame:
+		return frame.EndHeaders
+	default:
+		return true
+	}
+}
+
+func appendContinuation(frame Frame, continuation *ContinuationFrame) {
+	switch frame := frame.(type) {
+	case *HeadersFrame:
+		frame.Headers = append(frame.Headers, continuation.Headers...)
+		frame.HpackInfo = appendHpackInfo(frame.HpackInfo, continuation.HpackInfo)
+		frame.EndHeaders = continuation.EndHeaders
+	case *PushPromiseFrame:
+		frame.Headers = append(frame.Headers, continuation.Headers...)
+		frame.HpackInfo = appendHpackInfo(frame.HpackInfo, continuation.HpackInfo)
+		frame.EndHeaders = continuation.EndHeaders
+	}
+}
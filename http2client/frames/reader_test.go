@@ -0,0 +1,105 @@
+package frames
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestReadOversizedFrame(t *testing.T) {
+	var in bytes.Buffer
+	in.Write(encodeHeader(DATA_TYPE, 3, 2<<13+1, []Flag{}))
+	in.Write(make([]byte, 2<<13+1))
+	ping := NewPingFrame(0, 42, false)
+	encoded, _ := ping.Encode(NewEncodingContext())
+	in.Write(encoded)
+	reader := NewReader(&in, NewDecodingContext())
+	_, err := reader.ReadFrame()
+	assertFrameError(t, err, FRAME_SIZE_ERROR, true)
+	// The oversized payload must be skipped, so that the next frame can be read.
+	frame, err := reader.ReadFrame()
+	if err != nil {
+		t.Fatal("Decoding error:", err.Error())
+	}
+	if !reflect.DeepEqual(frame, ping) {
+		t.Error("Result does not equal expected frame.")
+	}
+}
+
+func TestReadFrameWithIncreasedMaxFrameSize(t *testing.T) {
+	frame := NewDataFrame(3, make([]byte, 2<<13+1), true)
+	encoded, _ := frame.Encode(NewEncodingContext())
+	context := NewDecodingContext()
+	context.SetMaxFrameSize(2 << 14)
+	result, err := NewReader(bytes.NewReader(encoded), context).ReadFrame()
+	if err != nil {
+		t.Fatal("Decoding error:", err.Error())
+	}
+	if !reflect.DeepEqual(frame, result) {
+		t.Error("Result does not equal expected frame.")
+	}
+}
+
+func TestReassembleHeaderBlock(t *testing.T) {
+	frame := makeExampleFrame()
+	context := NewEncodingContext()
+	context.SetMaxFrameSize(8) // split into CONTINUATION frames
+	encoded, err := frame.Encode(context)
+	if err != nil {
+		t.Fatal("Encoding error:", err.Error())
+	}
+	var raw bytes.Buffer
+	reader := NewReader(bytes.NewReader(encoded), NewDecodingContext())
+	reader.ReassembleHeaderBlocks(true)
+	reader.CaptureRawBytes(&raw)
+	result, err := reader.ReadFrame()
+	if err != nil {
+		t.Fatal("Decoding error:", err.Error())
+	}
+	if !reflect.DeepEqual(frame, result) {
+		t.Error("Result does not equal expected frame.")
+	}
+	if !bytes.Equal(raw.Bytes(), encoded) {
+		t.Error("Captured bytes do not equal the encoded frames.")
+	}
+}
+
+func TestInterleavedHeaderBlock(t *testing.T) {
+	frame := makeExampleFrame()
+	frame.EndHeaders = false
+	context := NewEncodingContext()
+	var in bytes.Buffer
+	encoded, _ := frame.Encode(context)
+	in.Write(encoded)
+	encoded, _ = NewPingFrame(0, 1, false).Encode(context)
+	in.Write(encoded)
+	reader := NewReader(&in, NewDecodingContext())
+	reader.ReassembleHeaderBlocks(true)
+	_, err := reader.ReadFrame()
+	assertFrameError(t, err, PROTOCOL_ERROR, true)
+}
+
+func TestFilterFramesBeforeReassembly(t *testing.T) {
+	frame := NewHeadersFrame(31, makeLargeHeaders())
+	encoded, encodedFrames, err := EncodeFrames(frame, NewEncodingContext())
+	if err != nil {
+		t.Fatal("Encoding error:", err.Error())
+	}
+	filtered := make([]Type, 0)
+	reader := NewReader(bytes.NewReader(encoded), NewDecodingContext())
+	reader.FilterFrames(func(f Frame) Frame {
+		filtered = append(filtered, f.Type())
+		return f
+	})
+	reader.ReassembleHeaderBlocks(true)
+	result, err := reader.ReadFrame()
+	if err != nil {
+		t.Fatal("Decoding error:", err.Error())
+	}
+	if !reflect.DeepEqual(frame, result) {
+		t.Error("Result does not equal expected frame.")
+	}
+	if len(filtered) != len(encodedFrames) || len(filtered) < 2 || filtered[1] != CONTINUATION_TYPE {
+		t.Errorf("Expected the filter to see the HEADERS and CONTINUATION frames, but got %v.", filtered)
+	}
+}
@@ -1,8 +1,8 @@
 package frames
 
 import (
+	"bytes"
 	"encoding/binary"
-	"fmt"
 )
 
 type RstStreamFrame struct {
@@ -18,8 +18,11 @@ func NewRstStreamFrame(streamId uint32, errorCode ErrorCode) *RstStreamFrame {
 }
 
 func DecodeRstStreamFrame(flags byte, streamId uint32, payload []byte, context *DecodingContext) (Frame, error) {
+	if err := requireStreamId(RST_STREAM_TYPE, streamId); err != nil {
+		return nil, err
+	}
 	if len(payload) != 4 {
-		return nil, fmt.Errorf("FRAME_SIZE_ERROR: Received RST_STREAM frame of length %v", len(payload))
+		return nil, newConnectionError(FRAME_SIZE_ERROR, "Received %v frame of length %v.", RST_STREAM_TYPE, len(payload))
 	}
 	return NewRstStreamFrame(streamId, ErrorCode(binary.BigEndian.Uint32(payload))), nil
 }
@@ -29,9 +32,12 @@ func (f *RstStreamFrame) Type() Type {
 }
 
 func (f *RstStreamFrame) Encode(context *EncodingContext) ([]byte, error) {
-	result := make([]byte, 4)
-	binary.BigEndian.PutUint32(result, uint32(f.ErrorCode))
-	return result, nil
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, uint32(f.ErrorCode))
+	var result bytes.Buffer
+	result.Write(encodeHeader(f.Type(), f.StreamId, uint32(len(payload)), []Flag{}))
+	result.Write(payload)
+	return result.Bytes(), nil
 }
 
 func (f *RstStreamFrame) GetStreamId() uint32 {
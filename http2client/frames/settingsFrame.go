@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
-	"os"
 )
 
 type Setting uint16
@@ -37,9 +36,7 @@ func (s Setting) String() string {
 	case SETTINGS_MAX_HEADER_LIST_SIZE:
 		return "SETTINGS_MAX_HEADER_LIST_SIZE"
 	default:
-		fmt.Fprintf(os.Stderr, "ERROR: Unknown setting %v", s)
-		os.Exit(-1)
-		return ""
+		return fmt.Sprintf("UNKNOWN_SETTING(0x%02X)", uint16(s))
 	}
 }
 
@@ -68,29 +65,47 @@ func NewSettingsFrame(streamId uint32, ack bool) *SettingsFrame {
 }
 
 func DecodeSettingsFrame(flags byte, streamId uint32, payload []byte, context *DecodingContext) (Frame, error) {
+	if err := requireStreamIdZero(SETTINGS_TYPE, streamId); err != nil {
+		return nil, err
+	}
+	ack := SETTINGS_FLAG_ACK.isSet(flags)
+	if ack && len(payload) != 0 {
+		return nil, newConnectionError(FRAME_SIZE_ERROR, "Received %v frame with ACK flag and length %v.", SETTINGS_TYPE, len(payload))
+	}
 	if len(payload)%6 != 0 {
-		return nil, fmt.Errorf("Invalid SETTINGS frame.")
+		return nil, newConnectionError(FRAME_SIZE_ERROR, "Received %v frame of length %v, which is not a multiple of 6.", SETTINGS_TYPE, len(payload))
 	}
-	result := NewSettingsFrame(streamId, false)
-	result.Ack = SETTINGS_FLAG_ACK.isSet(flags)
+	result := NewSettingsFrame(streamId, ack)
 	for i := 0; i < len(payload); i += 6 {
 		setting := Setting(binary.BigEndian.Uint16(payload[i : i+2]))
 		value := binary.BigEndian.Uint32(payload[i+2 : i+6])
-		if isUnknownSetting(setting) {
-			return nil, fmt.Errorf("Unknown setting in SETTINGS frame.")
+		// Unknown settings are kept, so that they are dumped and forwarded by the wiretap.
+		// The connection ignores them, see RFC 7540 section 6.5.2.
+		if err := validateSetting(setting, value); err != nil {
+			return nil, err
 		}
 		result.Settings[setting] = value
 	}
 	return result, nil
 }
 
-func isUnknownSetting(setting Setting) bool {
-	return setting != SETTINGS_HEADER_TABLE_SIZE &&
-		setting != SETTINGS_ENABLE_PUSH &&
-		setting != SETTINGS_MAX_CONCURRENT_STREAMS &&
-		setting != SETTINGS_INITIAL_WINDOW_SIZE &&
-		setting != SETTINGS_MAX_FRAME_SIZE &&
-		setting != SETTINGS_MAX_HEADER_LIST_SIZE
+// See RFC 7540 section 6.5.2.
+func validateSetting(setting Setting, value uint32) error {
+	switch setting {
+	case SETTINGS_ENABLE_PUSH:
+		if value > 1 {
+			return newConnectionError(PROTOCOL_ERROR, "Received %v with value %v.", setting, value)
+		}
+	case SETTINGS_INITIAL_WINDOW_SIZE:
+		if value > 2<<30-1 {
+			return newConnectionError(FLOW_CONTROL_ERROR, "Received %v with value %v.", setting, value)
+		}
+	case SETTINGS_MAX_FRAME_SIZE:
+		if value < 2<<13 || value > 2<<23-1 {
+			return newConnectionError(PROTOCOL_ERROR, "Received %v with value %v.", setting, value)
+		}
+	}
+	return nil
 }
 
 func (f *SettingsFrame) Type() Type {
@@ -0,0 +1,48 @@
+package frames
+
+import (
+	"bytes"
+)
+
+// UnknownFrame represents a frame of a type that is not implemented in h2c,
+// like extension frames defined after RFC 7540.
+//
+// RFC 7540 section 4.1: Implementations MUST ignore and discard any frame that has a type that is unknown.
+// The raw payload is kept, so that the frame can be dumped and forwarded without modification.
+type UnknownFrame struct {
+	FrameType Type
+	Flags     byte
+	StreamId  uint32
+	Payload   []byte
+}
+
+func NewUnknownFrame(frameType Type, flags byte, streamId uint32, payload []byte) *UnknownFrame {
+	return &UnknownFrame{
+		FrameType: frameType,
+		Flags:     flags,
+		StreamId:  streamId,
+		Payload:   payload,
+	}
+}
+
+// makeUnknownFrameDecoder returns a decoder that creates UnknownFrames of the given type.
+func makeUnknownFrameDecoder(frameType Type) func(flags byte, streamId uint32, payload []byte, context *DecodingContext) (Frame, error) {
+	return func(flags byte, streamId uint32, payload []byte, context *DecodingContext) (Frame, error) {
+		return NewUnknownFrame(frameType, flags, streamId, payload), nil
+	}
+}
+
+func (f *UnknownFrame) Type() Type {
+	return f.FrameType
+}
+
+func (f *UnknownFrame) Encode(context *EncodingContext) ([]byte, error) {
+	var result bytes.Buffer
+	result.Write(encodeHeader(f.Type(), f.StreamId, uint32(len(f.Payload)), []Flag{Flag(f.Flags)}))
+	result.Write(f.Payload)
+	return result.Bytes(), nil
+}
+
+func (f *UnknownFrame) GetStreamId() uint32 {
+	return f.StreamId
+}
@@ -0,0 +1,53 @@
+package frames
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestUnknownFrameIsForwardedUnmodified(t *testing.T) {
+	data := []byte{
+		0x00, 0x00, 0x05, // length
+		0xf0,                   // type
+		0xa5,                   // flags
+		0x00, 0x00, 0x00, 0x03, // stream id
+		0x01, 0x02, 0x03, 0x04, 0x05, // payload
+	}
+	frameHeader := DecodeHeader(data[0:9])
+	frame, err := FindDecoder(frameHeader.HeaderType)(frameHeader.Flags, frameHeader.StreamId, data[9:], NewDecodingContext())
+	if err != nil {
+		t.Fatal("Decoding error:", err.Error())
+	}
+	expected := NewUnknownFrame(0xf0, 0xa5, 3, []byte{0x01, 0x02, 0x03, 0x04, 0x05})
+	if !reflect.DeepEqual(frame, expected) {
+		t.Error("Result does not equal expected frame.")
+	}
+	encoded, err := frame.Encode(NewEncodingContext())
+	if err != nil {
+		t.Fatal("Encoding error:", err.Error())
+	}
+	if !bytes.Equal(encoded, data) {
+		t.Errorf("Expected %v, but got %v.", data, encoded)
+	}
+}
+
+func TestFrameNameToTypeHex(t *testing.T) {
+	for name, expected := range map[string]Type{
+		"0x0A":    0x0a,
+		"0xff":    0xff,
+		"0x1":     0x01,
+		"HEADERS": HEADERS_TYPE,
+	} {
+		result, ok := FrameNameToType(name)
+		if !ok || result != expected {
+			t.Errorf("Expected %v to be type 0x%02X.", name, byte(expected))
+		}
+	}
+	for _, name := range []string{"0x100", "0x", "UNKNOWN"} {
+		_, ok := FrameNameToType(name)
+		if ok {
+			t.Errorf("%v should not be a valid frame type.", name)
+		}
+	}
+}
@@ -1,6 +1,17 @@
 package frames
 
+import (
+	"regexp"
+	"strconv"
+)
+
+// FrameNameToType maps frame names like "HEADERS" to frame types.
+// Frame types that are not implemented in h2c can be specified as hex numbers, like "0x0A".
 func FrameNameToType(name string) (Type, bool) {
+	if regexp.MustCompile("^0[xX][0-9A-Fa-f]{1,2}$").MatchString(name) {
+		t, err := strconv.ParseUint(name[2:], 16, 8)
+		return Type(t), err == nil
+	}
 	t, ok := map[string]Type{
 		"DATA":          DATA_TYPE,
 		"HEADERS":       HEADERS_TYPE,
@@ -11,11 +22,14 @@ func FrameNameToType(name string) (Type, bool) {
 		"PING":          PING_TYPE,
 		"GOAWAY":        GOAWAY_TYPE,
 		"WINDOW_UPDATE": WINDOW_UPDATE_TYPE,
-		// TODO: "CONTINUATION"
+		"CONTINUATION":  CONTINUATION_TYPE,
+		"ALTSVC":        ALTSVC_TYPE,
+		"ORIGIN":        ORIGIN_TYPE,
 	}[name]
 	return t, ok
 }
 
+// AllFrameTypes returns the frame types implemented in h2c.
 func AllFrameTypes() []Type {
 	return []Type{
 		DATA_TYPE,
@@ -27,6 +41,8 @@ func AllFrameTypes() []Type {
 		PING_TYPE,
 		GOAWAY_TYPE,
 		WINDOW_UPDATE_TYPE,
-		// TODO: CONTINUATION
+		CONTINUATION_TYPE,
+		ALTSVC_TYPE,
+		ORIGIN_TYPE,
 	}
 }
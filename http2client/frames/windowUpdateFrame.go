@@ -3,7 +3,6 @@ package frames
 import (
 	"bytes"
 	"encoding/binary"
-	"fmt"
 )
 
 type WindowUpdateFrame struct {
@@ -19,10 +18,18 @@ func NewWindowUpdateFrame(streamId uint32, windowSizeIncrement uint32) *WindowUp
 }
 
 func DecodeWindowUpdateFrame(flags byte, streamId uint32, payload []byte, context *DecodingContext) (Frame, error) {
-	if len(payload) < 4 {
-		return nil, fmt.Errorf("FRAME_SIZE_ERROR: Received WINDOW_UPDATE frame of length %v", len(payload))
+	if len(payload) != 4 {
+		return nil, newConnectionError(FRAME_SIZE_ERROR, "Received %v frame of length %v.", WINDOW_UPDATE_TYPE, len(payload))
 	}
-	return NewWindowUpdateFrame(streamId, uint32_ignoreFirstBit(payload[0:4])), nil
+	increment := uint32_ignoreFirstBit(payload[0:4])
+	if increment == 0 {
+		// RFC 7540 section 6.9: Stream error on streams, connection error on the connection flow-control window.
+		if streamId == 0 {
+			return nil, newConnectionError(PROTOCOL_ERROR, "Received %v frame with increment 0.", WINDOW_UPDATE_TYPE)
+		}
+		return nil, newStreamError(streamId, PROTOCOL_ERROR, "Received %v frame with increment 0.", WINDOW_UPDATE_TYPE)
+	}
+	return NewWindowUpdateFrame(streamId, increment), nil
 }
 
 func (f *WindowUpdateFrame) Type() Type {
@@ -0,0 +1,77 @@
+package frames
+
+import (
+	"fmt"
+	"io"
+)
+
+// Writer writes HTTP/2 frames to an io.Writer.
+//
+// A Writer is not safe for concurrent use. The EncodingContext must not be shared with other Writers,
+// because it contains the HPACK encoding state of the connection.
+type Writer struct {
+	out     io.Writer
+	context *EncodingContext
+	capture io.Writer
+	filter  func(Frame) Frame
+}
+
+func NewWriter(out io.Writer, context *EncodingContext) *Writer {
+	return &Writer{
+		out:     out,
+		context: context,
+	}
+}
+
+// CaptureRawBytes configures an io.Writer receiving a copy of all bytes written.
+// Use nil to disable capturing.
+func (w *Writer) CaptureRawBytes(capture io.Writer) {
+	w.capture = capture
+}
+
+// FilterFrames configures a function that is called with each frame after it was encoded, and before it is written.
+// If a header block was split, the filter is called with the HEADERS or PUSH_PROMISE frame followed by
+// the CONTINUATION frames, see EncodeFrames(). The frame returned by the filter is ignored, because the frame
+// is already encoded. Use nil to disable filtering.
+func (w *Writer) FilterFrames(filter func(Frame) Frame) {
+	w.filter = filter
+}
+
+// WriteFrame encodes the frame and writes it.
+// HEADERS and PUSH_PROMISE frames are split into CONTINUATION frames if necessary.
+// Other frames exceeding the max frame size of the EncodingContext are not written, and an error is returned.
+func (w *Writer) WriteFrame(frame Frame) error {
+	encodedFrame, encodedFrames, err := EncodeFrames(frame, w.context)
+	if err != nil {
+		return fmt.Errorf("Failed to encode %v frame: %v", frame.Type(), err.Error())
+	}
+	if w.filter != nil {
+		for _, f := range encodedFrames {
+			w.filter(f)
+		}
+	}
+	err = w.checkFrameSize(encodedFrame)
+	if err != nil {
+		return err
+	}
+	_, err = w.out.Write(encodedFrame)
+	if err != nil {
+		return fmt.Errorf("Failed to write %v frame: %v", frame.Type(), err.Error())
+	}
+	if w.capture != nil {
+		w.capture.Write(encodedFrame)
+	}
+	return nil
+}
+
+// The encoded data may contain more than one frame if CONTINUATION frames were created.
+func (w *Writer) checkFrameSize(data []byte) error {
+	for len(data) >= 9 {
+		header := DecodeHeader(data[0:9])
+		if header.Length > w.context.MaxFrameSize() {
+			return fmt.Errorf("Failed to write %v frame: Length %v exceeds max frame size %v.", header.HeaderType, header.Length, w.context.MaxFrameSize())
+		}
+		data = data[9+int(header.Length):]
+	}
+	return nil
+}
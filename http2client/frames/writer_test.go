@@ -0,0 +1,64 @@
+package frames
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestWriteOversizedFrame(t *testing.T) {
+	var out, raw bytes.Buffer
+	writer := NewWriter(&out, NewEncodingContext())
+	writer.CaptureRawBytes(&raw)
+	err := writer.WriteFrame(NewDataFrame(3, make([]byte, 2<<13+1), true))
+	if err == nil || out.Len() > 0 {
+		t.Error("Expected error for DATA frame exceeding the max frame size.")
+	}
+	err = writer.WriteFrame(NewDataFrame(3, make([]byte, 2<<13), true))
+	if err != nil {
+		t.Error("Encoding error:", err.Error())
+	}
+	if out.Len() != 9+2<<13 || !bytes.Equal(out.Bytes(), raw.Bytes()) {
+		t.Error("Expected the frame to be written and captured.")
+	}
+}
+
+func TestFilterContinuationFrames(t *testing.T) {
+	var out bytes.Buffer
+	filtered := make([]Frame, 0)
+	encodingContext := NewEncodingContext()
+	encodingContext.EnableHpackInfo()
+	writer := NewWriter(&out, encodingContext)
+	writer.FilterFrames(func(f Frame) Frame {
+		if out.Len() > 0 {
+			t.Error("Expected the filter to be called before the frame is written.")
+		}
+		filtered = append(filtered, f)
+		return f
+	})
+	frame := NewHeadersFrame(31, makeLargeHeaders())
+	err := writer.WriteFrame(frame)
+	if err != nil {
+		t.Fatal("Encoding error:", err.Error())
+	}
+	if frame.HpackInfo == nil || len(frame.HpackInfo.Fields) != len(frame.Headers) {
+		t.Error("Expected the HpackInfo of the complete header block.")
+	}
+	decoded := make([]Frame, 0)
+	decodingContext := NewDecodingContext()
+	decodingContext.EnableHpackInfo()
+	reader := NewReader(&out, decodingContext)
+	for out.Len() > 0 {
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			t.Fatal("Decoding error:", err.Error())
+		}
+		decoded = append(decoded, frame)
+	}
+	if len(decoded) < 2 {
+		t.Fatalf("Expected the header block to be split into CONTINUATION frames, but got %v frames.", len(decoded))
+	}
+	if !reflect.DeepEqual(filtered, decoded) {
+		t.Error("Filtered frames do not equal the frames written.")
+	}
+}
@@ -1,60 +1,137 @@
 package connection
 
 import (
+	"bufio"
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"github.com/fstab/h2c/http2client/flowcontrol"
 	"github.com/fstab/h2c/http2client/frames"
 	"github.com/fstab/h2c/http2client/internal/eventloop/commands"
 	"github.com/fstab/h2c/http2client/internal/stream"
 	"github.com/fstab/h2c/http2client/internal/streamstate"
 	"github.com/fstab/h2c/http2client/internal/util"
+	"github.com/fstab/h2c/http2client/tlsconfig"
 	"golang.org/x/net/http2/hpack"
 	"io"
+	"math"
 	"net"
 	"os"
+	"time"
 )
 
 const CLIENT_PREFACE = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
 
+const SERVER_PREFACE_TIMEOUT = 5 * time.Second
+
+// If the server does not acknowledge our SETTINGS within this time, the connection is closed with SETTINGS_TIMEOUT,
+// see RFC 7540 section 6.5.3.
+const SETTINGS_ACK_TIMEOUT = 10 * time.Second
+
+// Payload of the PING frames measuring the round trip time for flowcontrol.Tuner policies.
+// PING frames sent with 'h2c ping' count up from 0, so they will not use this payload.
+const BDP_PING_PAYLOAD = 0x6264702d70696e67 // "bdp-ping"
+
+// Requests exceeding the server's SETTINGS_MAX_CONCURRENT_STREAMS wait until a stream is closed.
+// If more requests are waiting, new requests fail.
+const MAX_QUEUED_REQUESTS = 100
+
+// Closed streams are removed from the connection. The most recently closed streams are kept for 'h2c stream-info --closed'.
+const MAX_CLOSED_STREAMS = 100
+
+// Largest stream identifier, see RFC 7540 section 5.1.1. When the client used it up, new requests are sent on a new connection.
+const MAX_STREAM_ID = 2<<30 - 1
+
 // Some of these methods may no longer be needed after the last refactoring. Need to clean up.
 type Connection interface {
 	HandleIncomingFrame(frame frames.Frame)
+	HandleFrameError(err *frames.FrameError)
 	ExecuteHttpCommand(cmd *commands.HttpCommand)
 	ExecuteMonitoringCommand(cmd *commands.MonitoringCommand)
 	ExecutePingCommand(cmd *commands.PingCommand)
+	ExecuteWindowUpdateCommand(cmd *commands.WindowUpdateCommand)
+	CheckTimeouts(now time.Time)
 	ReadNextFrame() (frames.Frame, error)
+	HandleReadError(err error)
 	Shutdown()
 	IsShutdown() bool
+	CloseReason() error
 }
 
 type connection struct {
 	info                       *info
 	settings                   *settings
-	streams                    map[uint32]stream.Stream // StreamID -> *stream
+	streams                    map[uint32]stream.Stream // StreamID -> *stream, closed streams are removed.
+	closedStreams              []stream.Stream          // The last MAX_CLOSED_STREAMS closed streams, oldest first.
+	nextStreamId               uint32                   // Next client-initiated stream, greater than MAX_STREAM_ID when the ids are used up.
+	refusedRequests            bool                     // A request failed because the ids are used up, so it was sent on a new connection.
 	promisedStreamCache        map[uint32]stream.Stream // StreamID -> *stream
 	nextPingId                 uint64
 	pendingPingCommands        map[uint64]*commands.PingCommand
+	queuedHttpCommands         []*commands.HttpCommand // Requests waiting because of SETTINGS_MAX_CONCURRENT_STREAMS.
 	conn                       net.Conn
 	isShutdown                 bool
 	encodingContext            *frames.EncodingContext
 	decodingContext            *frames.DecodingContext
+	reader                     *frames.Reader
+	writer                     *frames.Writer
 	remainingSendWindowSize    int64
 	remainingReceiveWindowSize int64
+	receiveWindowSize          int64              // Size of the connection's receive window, may be increased by the receivePolicy.
+	receivePolicy              flowcontrol.Policy // Decides when WINDOW_UPDATE frames are sent for received DATA frames.
+	bdpPing                    *bdpPing           // PING frame sent for measuring the round trip time, nil if there is none in flight.
 	incomingFrameFilters       []func(frames.Frame) frames.Frame
 	outgoingFrameFilters       []func(frames.Frame) frames.Frame
-	err                        error // TODO: not used
+	alternativeServices        map[string]string   // Origin -> Alt-Svc field value, received in ALTSVC frames
+	originSet                  map[string]bool     // Origins received in ORIGIN frames, nil until the first ORIGIN frame is received
+	err                        error               // TODO: not used
+	goAway                     *frames.GoAwayFrame // Received from the server, nil if no GOAWAY was received.
+	highestPeerStreamId        uint32              // Highest stream id initiated by the server, sent as last stream id in GOAWAY.
+	closeReason                error               // Why the connection was shut down, nil while the connection is open.
+	settingsAckDeadlines       []time.Time         // One entry for each SETTINGS frame sent that was not acknowledged yet.
+	pendingDataStreams         []stream.Stream     // Streams with pending DATA frames in round-robin order.
+}
+
+// Options are the settings for establishing a connection.
+type Options struct {
+	MaxFrameSize    uint32  // SETTINGS_MAX_FRAME_SIZE sent to the server. 0 means the initial value.
+	HeaderTableSize *uint32 // SETTINGS_HEADER_TABLE_SIZE sent to the server. nil means the initial value.
+	DisableHuffman  bool
+	HpackInfo       bool               // Set frames.HpackInfo in HEADERS, PUSH_PROMISE, and CONTINUATION frames passed to the filters.
+	Upgrade         bool               // Use the HTTP/1.1 Upgrade mechanism instead of prior knowledge. Only for scheme "http".
+	Tls             *tlsconfig.Options // nil means the server certificate is verified against the system's root CAs.
+	Proxy           string             // URL of an HTTP CONNECT or socks5 proxy. Empty means the server is connected directly.
+	NoProxy         string             // Comma separated list of hosts that are connected directly, like the NO_PROXY environment variable.
+	UnixSocket      string             // Path of a unix domain socket to connect to instead of host and port. Host and port are still used for :authority.
+	// Decides when WINDOW_UPDATE frames are sent for received DATA frames. nil means flowcontrol.Threshold(flowcontrol.DEFAULT_THRESHOLD).
+	ReceiveFlowControl flowcontrol.Policy
+	// Receive window size for the connection and for new streams, sent as SETTINGS_INITIAL_WINDOW_SIZE. 0 means the initial value 65535.
+	ReceiveWindowSize uint32
 }
 
 type info struct {
-	host string
-	port int
+	scheme      string
+	host        string
+	port        int
+	tlsChain    []string // Summary of the server's certificate chain, nil for cleartext connections.
+	tlsVerified bool
+	serverName  string // Host name sent with SNI, empty for cleartext connections or if no SNI was sent.
+	unixSocket  string // Path of the unix domain socket, empty for TCP connections.
 }
 
 type settings struct {
 	serverFrameSize                       uint32
+	clientFrameSize                       uint32 // SETTINGS_MAX_FRAME_SIZE sent to the server
+	clientHeaderTableSize                 uint32 // SETTINGS_HEADER_TABLE_SIZE sent to the server
 	initialSendWindowSizeForNewStreams    uint32
 	initialReceiveWindowSizeForNewStreams uint32
+	serverMaxHeaderListSize               uint32 // SETTINGS_MAX_HEADER_LIST_SIZE received from the server
+	serverMaxConcurrentStreams            uint32 // SETTINGS_MAX_CONCURRENT_STREAMS received from the server
+}
+
+type bdpPing struct {
+	sentAt            time.Time
+	bytesPerRoundTrip int64 // DATA received since the PING was sent.
 }
 
 type writeFrameRequest struct {
@@ -62,28 +139,142 @@ type writeFrameRequest struct {
 	task  *util.AsyncTask
 }
 
-func Start(host string, port int, incomingFrameFilters []func(frames.Frame) frames.Frame, outgoingFrameFilters []func(frames.Frame) frames.Frame) (Connection, error) {
+// Start connects to the server. If scheme is "http", HTTP/2 is used without TLS (cleartext HTTP/2 with prior knowledge,
+// see RFC 7540 section 3.4, or with options.Upgrade, see RFC 7540 section 3.2). Otherwise, HTTP/2 is negotiated via TLS-ALPN.
+func Start(scheme string, host string, port int, options *Options, incomingFrameFilters []func(frames.Frame) frames.Frame, outgoingFrameFilters []func(frames.Frame) frames.Frame) (Connection, error) {
 	hostAndPort := fmt.Sprintf("%v:%v", host, port)
-	supportedProtocols := []string{"h2", "h2-16"} // The netty server still uses h2-16, treat it as if it was h2.
-	conn, err := tls.Dial("tcp", hostAndPort, &tls.Config{
-		InsecureSkipVerify: true,
-		NextProtos:         supportedProtocols,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("Failed to connect to %v: %v", hostAndPort, err.Error())
+	var conn net.Conn
+	var err error
+	clientCertRequested := false
+	if options.UnixSocket != "" {
+		conn, err = net.Dial("unix", options.UnixSocket)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to connect to %v: %v", options.UnixSocket, err.Error())
+		}
+	} else {
+		conn, err = dial(host, hostAndPort, options.Proxy, options.NoProxy)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if scheme != "http" {
+		conn, clientCertRequested, err = startTls(conn, host, hostAndPort, options.Tls)
+		if err != nil {
+			return nil, err
+		}
+	}
+	c := newConnection(conn, scheme, host, port, incomingFrameFilters, outgoingFrameFilters)
+	c.info.unixSocket = options.UnixSocket
+	if tlsConn, isTls := conn.(*tls.Conn); isTls {
+		c.info.tlsChain = tlsconfig.ChainSummary(tlsConn.ConnectionState())
+		c.info.tlsVerified = options.Tls == nil || !options.Tls.Insecure
+		c.info.serverName = tlsConn.ConnectionState().ServerName
 	}
-	if !util.SliceContainsString(supportedProtocols, conn.ConnectionState().NegotiatedProtocol) {
-		return nil, fmt.Errorf("Server does not support HTTP/2 protocol.")
+	if options.MaxFrameSize != 0 {
+		c.settings.clientFrameSize = options.MaxFrameSize
+	}
+	if options.HeaderTableSize != nil {
+		c.settings.clientHeaderTableSize = *options.HeaderTableSize
+	}
+	if options.ReceiveFlowControl != nil {
+		c.receivePolicy = options.ReceiveFlowControl
+	}
+	if options.ReceiveWindowSize != 0 {
+		c.settings.initialReceiveWindowSizeForNewStreams = options.ReceiveWindowSize
+	}
+	c.encodingContext.SetHuffman(!options.DisableHuffman)
+	if options.HpackInfo {
+		c.encodingContext.EnableHpackInfo()
+		c.decodingContext.EnableHpackInfo()
+	}
+	settingsFrame := frames.NewSettingsFrame(0, false)
+	settingsFrame.Settings[frames.SETTINGS_MAX_FRAME_SIZE] = c.settings.clientFrameSize
+	settingsFrame.Settings[frames.SETTINGS_HEADER_TABLE_SIZE] = c.settings.clientHeaderTableSize
+	if c.settings.initialReceiveWindowSizeForNewStreams != flowcontrol.DEFAULT_WINDOW_SIZE {
+		settingsFrame.Settings[frames.SETTINGS_INITIAL_WINDOW_SIZE] = c.settings.initialReceiveWindowSizeForNewStreams
+	}
+	if options.Upgrade {
+		err = c.upgrade(settingsFrame)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
 	}
 	_, err = conn.Write([]byte(CLIENT_PREFACE))
 	if err != nil {
 		return nil, fmt.Errorf("Failed to write client preface to %v: %v", hostAndPort, err.Error())
 	}
-	c := newConnection(conn, host, port, incomingFrameFilters, outgoingFrameFilters)
-	c.Write(frames.NewSettingsFrame(0, false))
+	// Larger frames may be sent as soon as the server received our SETTINGS, so the limit is applied right away.
+	c.decodingContext.SetMaxFrameSize(c.settings.clientFrameSize)
+	// The server must send a dynamic table size update before using a smaller table, so this can also be applied right away.
+	c.decodingContext.SetHeaderTableSize(c.settings.clientHeaderTableSize)
+	c.Write(settingsFrame)
+	// The connection's window is not changed by SETTINGS_INITIAL_WINDOW_SIZE, see RFC 7540 section 6.9.2.
+	if c.settings.initialReceiveWindowSizeForNewStreams > flowcontrol.DEFAULT_WINDOW_SIZE {
+		c.growReceiveWindow(int64(c.settings.initialReceiveWindowSizeForNewStreams))
+	}
+	if clientCertRequested && conn.(*tls.Conn).ConnectionState().Version >= tls.VersionTLS13 {
+		err = c.awaitServerPreface()
+		if err != nil {
+			conn.Close()
+			if certErr := tlsconfig.ClientCertificateError(options.Tls, err, true); certErr != nil {
+				err = certErr
+			}
+			return nil, fmt.Errorf("Failed to connect to %v: %v", hostAndPort, err.Error())
+		}
+	}
 	return c, nil
 }
 
+// awaitServerPreface waits until the server starts sending frames.
+// With TLS 1.3, the server verifies the client certificate after the handshake is complete from the client's point of view,
+// so an alert rejecting the client certificate is only noticed when reading from the connection.
+// If the server does not respond within SERVER_PREFACE_TIMEOUT, the connection is used anyway.
+func (c *connection) awaitServerPreface() error {
+	in := bufio.NewReader(c.conn)
+	c.conn.SetReadDeadline(time.Now().Add(SERVER_PREFACE_TIMEOUT))
+	_, err := in.Peek(1)
+	c.conn.SetReadDeadline(time.Time{})
+	if netErr, isNetErr := err.(net.Error); err != nil && !(isNetErr && netErr.Timeout()) {
+		return err
+	}
+	c.reader = c.newReader(in)
+	return nil
+}
+
+// startTls performs the TLS handshake on conn, and also reports if the server requested a client certificate.
+// conn is closed if the handshake fails.
+func startTls(conn net.Conn, host string, hostAndPort string, options *tlsconfig.Options) (net.Conn, bool, error) {
+	supportedProtocols := []string{"h2", "h2-16"} // The netty server still uses h2-16, treat it as if it was h2.
+	config, err := tlsconfig.New(host, supportedProtocols, options)
+	if err != nil {
+		conn.Close()
+		return nil, false, err
+	}
+	clientCertRequested := false
+	tlsconfig.OnClientCertificateRequest(config, func() {
+		clientCertRequested = true
+	})
+	tlsConn := tls.Client(conn, config)
+	err = tlsConn.Handshake()
+	if err != nil {
+		conn.Close()
+		var verificationErr *tls.CertificateVerificationError
+		if errors.As(err, &verificationErr) {
+			return nil, false, fmt.Errorf("Failed to verify the certificate of %v: %v. Use --insecure to skip certificate verification.", hostAndPort, verificationErr.Err.Error())
+		}
+		if certErr := tlsconfig.ClientCertificateError(options, err, clientCertRequested); certErr != nil {
+			err = certErr
+		}
+		return nil, false, fmt.Errorf("Failed to connect to %v: %v", hostAndPort, err.Error())
+	}
+	if !util.SliceContainsString(supportedProtocols, tlsConn.ConnectionState().NegotiatedProtocol) {
+		tlsConn.Close()
+		return nil, false, fmt.Errorf("Server does not support HTTP/2 protocol.")
+	}
+	return tlsConn, clientCertRequested, nil
+}
+
 func (conn *connection) ExecuteHttpCommand(cmd *commands.HttpCommand) {
 	if conn.error() != nil {
 		cmd.CompleteWithError(conn.error())
@@ -126,40 +317,138 @@ func (conn *connection) executePostCommand(cmd *commands.HttpCommand) {
 }
 
 func (conn *connection) doRequest(cmd *commands.HttpCommand) {
+	if conn.goAway != nil {
+		// RFC 7540 section 6.8: No new streams may be opened after receiving GOAWAY.
+		cmd.CompleteWithError(&commands.NotProcessedError{GoAway: conn.goAway})
+		return
+	}
+	if conn.nextStreamId > MAX_STREAM_ID {
+		cmd.CompleteWithError(&commands.StreamIdsExhaustedError{})
+		conn.refusedRequests = true
+		conn.closeIfGoAwayCompleted()
+		return
+	}
+	size := headerListSize(cmd.Request.GetHeaders())
+	if size > uint64(conn.settings.serverMaxHeaderListSize) {
+		cmd.CompleteWithError(fmt.Errorf("The request header list has %v octets, but the server's %v is %v.", size, frames.SETTINGS_MAX_HEADER_LIST_SIZE, conn.settings.serverMaxHeaderListSize))
+		return
+	}
+	if conn.nActiveClientStreams() >= conn.settings.serverMaxConcurrentStreams {
+		conn.removeCancelledRequests()
+		if len(conn.queuedHttpCommands) >= MAX_QUEUED_REQUESTS {
+			cmd.CompleteWithError(fmt.Errorf("Too many requests: %v requests are already waiting, because the server's %v is %v.", len(conn.queuedHttpCommands), frames.SETTINGS_MAX_CONCURRENT_STREAMS, conn.settings.serverMaxConcurrentStreams))
+			return
+		}
+		conn.queuedHttpCommands = append(conn.queuedHttpCommands, cmd)
+		return
+	}
 	stream := conn.newStream(cmd)
 	headersFrame := frames.NewHeadersFrame(stream.StreamId(), cmd.Request.GetHeaders())
 	headersFrame.EndStream = len(cmd.Request.GetBody()) == 0
+	headersFrame.Padded = cmd.Padded
+	headersFrame.PadLength = cmd.PadLength
+	headersFrame.Priority = cmd.Priority
+	headersFrame.StreamDependency = cmd.StreamDependency
+	headersFrame.Exclusive = cmd.Exclusive
+	headersFrame.Weight = cmd.Weight
 	stream.SendFrame(headersFrame)
 	if len(cmd.Request.GetBody()) > 0 {
-		conn.sendDataFrames(cmd.Request.GetBody(), stream)
+		// The stream splits the body into DATA frames when it is sent, see stream.SendNextPendingDataFrame().
+		dataFrame := frames.NewDataFrame(stream.StreamId(), cmd.Request.GetBody(), true)
+		dataFrame.Padded = cmd.Padded
+		dataFrame.PadLength = cmd.PadLength
+		stream.SendFrame(dataFrame)
 	}
 }
 
-func (conn *connection) sendDataFrames(data []byte, stream stream.Stream) {
-	// chunkSize := uint32(len(data)) // use this to provoke GOAWAY frame with FRAME_SIZE_ERROR
-	chunkSize := conn.serverFrameSize() // TODO: Query chunk size with each iteration -> allow changes during loop
-	nChunksSent := uint32(0)
-	total := uint32(len(data))
-	for nChunksSent*chunkSize < total {
-		nextChunk := data[nChunksSent*chunkSize : min((nChunksSent+1)*chunkSize, total)]
-		nChunksSent = nChunksSent + 1
-		isLast := nChunksSent*chunkSize >= total
-		dataFrame := frames.NewDataFrame(stream.StreamId(), nextChunk, isLast)
-		stream.SendFrame(dataFrame)
+// RFC 7540 section 5.1.2: Streams in the open or half-closed states count toward SETTINGS_MAX_CONCURRENT_STREAMS.
+// Streams reserved by PUSH_PROMISE are initiated by the server, so they do not count.
+func (conn *connection) nActiveClientStreams() uint32 {
+	result := uint32(0)
+	for id, s := range conn.streams {
+		if id%2 == 1 && s.GetState().In(streamstate.OPEN, streamstate.HALF_CLOSED_LOCAL, streamstate.HALF_CLOSED_REMOTE) {
+			result++
+		}
+	}
+	return result
+}
+
+// startQueuedRequests is called when a stream was closed, or when the server may have increased
+// SETTINGS_MAX_CONCURRENT_STREAMS.
+func (conn *connection) startQueuedRequests() {
+	if conn.isShutdown {
+		return
+	}
+	conn.removeCancelledRequests()
+	for len(conn.queuedHttpCommands) > 0 && conn.nActiveClientStreams() < conn.settings.serverMaxConcurrentStreams {
+		cmd := conn.queuedHttpCommands[0]
+		conn.queuedHttpCommands = conn.queuedHttpCommands[1:]
+		conn.doRequest(cmd)
+	}
+}
+
+// removeCancelledRequests drops queued requests whose caller stopped waiting, like after a timeout.
+func (conn *connection) removeCancelledRequests() {
+	remaining := make([]*commands.HttpCommand, 0, len(conn.queuedHttpCommands))
+	for _, cmd := range conn.queuedHttpCommands {
+		if !cmd.IsCancelled() {
+			remaining = append(remaining, cmd)
+		}
 	}
+	conn.queuedHttpCommands = remaining
 }
 
-func min(a, b uint32) uint32 {
-	if a < b {
-		return a
+func (conn *connection) failQueuedRequests(err error) {
+	for _, cmd := range conn.queuedHttpCommands {
+		cmd.CompleteWithError(err)
 	}
-	return b
+	conn.queuedHttpCommands = nil
+}
+
+// Size as defined for SETTINGS_MAX_HEADER_LIST_SIZE in RFC 7540 section 6.5.2.
+func headerListSize(headers []hpack.HeaderField) uint64 {
+	result := uint64(0)
+	for _, header := range headers {
+		result += uint64(len(header.Name) + len(header.Value) + 32)
+	}
+	return result
 }
 
 func (c *connection) ExecuteMonitoringCommand(cmd *commands.MonitoringCommand) {
-	for _, s := range c.streams {
+	// Cached push promises may be closed streams that are no longer in the history.
+	allStreams := make(map[uint32]stream.Stream)
+	for _, s := range c.closedStreams {
+		allStreams[s.StreamId()] = s
+	}
+	for id, s := range c.promisedStreamCache {
+		allStreams[id] = s
+	}
+	for id, s := range c.streams {
+		allStreams[id] = s
+	}
+	for _, s := range allStreams {
 		_, isCachedPushPromise := c.promisedStreamCache[s.StreamId()]
-		cmd.Result.AddStreamInfo(s.StreamId(), findHeader(":method", s.RequestHeaders()), findHeader(":path", s.RequestHeaders()), s.GetState(), isCachedPushPromise)
+		sendWindow, receiveWindow := s.FlowControlWindows()
+		cmd.Result.AddStreamInfo(s.StreamId(), findHeader(":method", s.RequestHeaders()), findHeader(":path", s.RequestHeaders()), s.GetState(), isCachedPushPromise, sendWindow, receiveWindow)
+	}
+	c.removeCancelledRequests()
+	for _, queued := range c.queuedHttpCommands {
+		cmd.Result.AddQueuedRequest(queued.Request.GetHeader(":method"), queued.Request.GetHeader(":path"))
+	}
+	cmd.Result.SetFlowControlInfo(c.remainingSendWindowSize, c.remainingReceiveWindowSize, c.receivePolicy.String())
+	cmd.Result.SetOrigin(origin(c.info.scheme, c.host(), c.port()))
+	cmd.Result.ConnectionInfo.UnixSocket = c.info.unixSocket
+	if c.goAway != nil {
+		cmd.Result.ConnectionInfo.GoAway = commands.DescribeGoAway(c.goAway)
+	}
+	if c.info.tlsChain != nil {
+		cmd.Result.SetTlsInfo(c.info.tlsVerified, c.info.tlsChain)
+	}
+	for o := range c.originSet {
+		cmd.Result.AddOrigin(o)
+	}
+	for o, value := range c.alternativeServices {
+		cmd.Result.AddAlternativeService(o, value)
 	}
 	cmd.CompleteSuccessfully()
 }
@@ -182,18 +471,36 @@ func (c *connection) ExecutePingCommand(cmd *commands.PingCommand) {
 	c.Write(pingFrame)
 }
 
-func newConnection(conn net.Conn, host string, port int, incomingFrameFilters []func(frames.Frame) frames.Frame, outgoingFrameFilters []func(frames.Frame) frames.Frame) *connection {
-	return &connection{
+// ExecuteWindowUpdateCommand sends the WINDOW_UPDATE frame even if the increment is invalid or the stream does not exist.
+func (c *connection) ExecuteWindowUpdateCommand(cmd *commands.WindowUpdateCommand) {
+	if cmd.StreamId == 0 {
+		c.remainingReceiveWindowSize += int64(cmd.Increment)
+	} else if s, exists := c.getStreamIfExists(cmd.StreamId); exists {
+		s.IncreaseReceiveFlowControlWindow(int64(cmd.Increment))
+	}
+	c.Write(frames.NewWindowUpdateFrame(cmd.StreamId, cmd.Increment))
+	cmd.CompleteSuccessfully()
+}
+
+func newConnection(conn net.Conn, scheme string, host string, port int, incomingFrameFilters []func(frames.Frame) frames.Frame, outgoingFrameFilters []func(frames.Frame) frames.Frame) *connection {
+	c := &connection{
 		info: &info{
-			host: host,
-			port: port,
+			scheme: scheme,
+			host:   host,
+			port:   port,
 		},
 		settings: &settings{
 			serverFrameSize:                       2 << 13,   // Minimum size that must be supported by all server implementations.
+			clientFrameSize:                       2 << 13,   // Initial value of SETTINGS_MAX_FRAME_SIZE.
+			clientHeaderTableSize:                 4096,      // Initial value of SETTINGS_HEADER_TABLE_SIZE.
 			initialSendWindowSizeForNewStreams:    2<<15 - 1, // Initial flow-control window size for new streams is 65,535 octets.
 			initialReceiveWindowSizeForNewStreams: 2<<15 - 1,
+			serverMaxHeaderListSize:               math.MaxUint32, // The initial value is unlimited.
+			serverMaxConcurrentStreams:            math.MaxUint32, // The initial value is unlimited.
 		},
 		streams:                    make(map[uint32]stream.Stream),
+		closedStreams:              make([]stream.Stream, 0),
+		nextStreamId:               1,
 		promisedStreamCache:        make(map[uint32]stream.Stream),
 		pendingPingCommands:        make(map[uint64]*commands.PingCommand),
 		isShutdown:                 false,
@@ -201,28 +508,75 @@ func newConnection(conn net.Conn, host string, port int, incomingFrameFilters []
 		encodingContext:            frames.NewEncodingContext(),
 		decodingContext:            frames.NewDecodingContext(),
 		remainingSendWindowSize:    2<<15 - 1,
-		remainingReceiveWindowSize: 2<<15 - 1,
+		remainingReceiveWindowSize: flowcontrol.DEFAULT_WINDOW_SIZE,
+		receiveWindowSize:          flowcontrol.DEFAULT_WINDOW_SIZE,
+		receivePolicy:              flowcontrol.Threshold(flowcontrol.DEFAULT_THRESHOLD),
 		incomingFrameFilters:       incomingFrameFilters,
 		outgoingFrameFilters:       outgoingFrameFilters,
+		alternativeServices:        make(map[string]string),
 	}
+	c.reader = c.newReader(conn)
+	c.writer = frames.NewWriter(conn, c.encodingContext)
+	c.writer.FilterFrames(c.filterOutgoingFrame)
+	return c
 }
 
+// Shutdown closes the connection gracefully with a GOAWAY frame, see RFC 7540 section 6.8.
+// Requests that did not complete yet fail.
 func (c *connection) Shutdown() {
+	if c.isShutdown {
+		return
+	}
+	c.Write(frames.NewGoAwayFrame(0, c.highestPeerStreamId, frames.NO_ERROR))
+	c.close(fmt.Errorf("Connection closed by client."))
+}
+
+// HandleReadError is called when the connection cannot be read anymore, for example because the server closed it.
+func (c *connection) HandleReadError(err error) {
+	if c.goAway != nil {
+		c.close(fmt.Errorf("Server closed the connection after sending %v.", commands.DescribeGoAway(c.goAway)))
+	} else {
+		c.close(fmt.Errorf("Connection closed: %v", err.Error()))
+	}
+}
+
+// close shuts down the connection without sending GOAWAY. Requests that did not complete yet fail with reason.
+func (c *connection) close(reason error) {
+	if c.isShutdown {
+		return
+	}
 	c.isShutdown = true
+	c.closeReason = reason
 	c.conn.Close()
+	for _, s := range c.streams {
+		s.Abort(reason)
+	}
+	for payload, cmd := range c.pendingPingCommands {
+		delete(c.pendingPingCommands, payload)
+		cmd.CompleteWithError(reason)
+	}
+	c.failQueuedRequests(reason)
 }
 
 func (c *connection) IsShutdown() bool {
 	return c.isShutdown
 }
 
+func (c *connection) CloseReason() error {
+	return c.closeReason
+}
+
 func (c *connection) HandleIncomingFrame(frame frames.Frame) {
+	if _, isUnknown := frame.(*frames.UnknownFrame); isUnknown {
+		return // Frames of unknown type must be ignored, see RFC 7540 section 4.1.
+	}
 	streamId := frame.GetStreamId()
 	if streamId == 0 {
 		c.handleFrameForConnection(frame)
 	} else {
 		c.handleFrameForStream(frame)
 	}
+	c.closeIfGoAwayCompleted()
 }
 
 func (c *connection) handleFrameForConnection(frame frames.Frame) {
@@ -230,7 +584,9 @@ func (c *connection) handleFrameForConnection(frame frames.Frame) {
 	case *frames.SettingsFrame:
 		c.handleSettingsFrame(frame)
 	case *frames.PingFrame:
-		if frame.Ack {
+		if frame.Ack && frame.Payload == BDP_PING_PAYLOAD {
+			c.handleBdpPingAck()
+		} else if frame.Ack {
 			pendingPingCommand, exists := c.pendingPingCommands[frame.Payload]
 			if exists {
 				delete(c.pendingPingCommands, frame.Payload)
@@ -243,19 +599,75 @@ func (c *connection) handleFrameForConnection(frame frames.Frame) {
 	case *frames.WindowUpdateFrame:
 		c.handleWindowUpdateFrame(frame)
 	case *frames.GoAwayFrame:
-		c.Shutdown()
+		c.handleGoAwayFrame(frame)
+	case *frames.AltSvcFrame:
+		c.handleAltSvcFrame(frame)
+	case *frames.OriginFrame:
+		c.handleOriginFrame(frame)
 	default:
 		msg := fmt.Sprintf("Received %v frame with stream identifier 0x00.", frame.Type())
 		c.connectionError(frames.PROTOCOL_ERROR, msg)
 	}
 }
 
+// RFC 7540 section 6.8: Streams up to the last stream id may still complete. Streams with higher ids
+// were not processed by the server, so their requests fail with a commands.NotProcessedError and may be retried.
+// The server may send more than one GOAWAY frame, each with a last stream id lower than or equal to the previous one.
+func (c *connection) handleGoAwayFrame(frame *frames.GoAwayFrame) {
+	c.goAway = frame
+	for id, s := range c.streams {
+		if id%2 == 1 && id > frame.LastStreamId {
+			s.Abort(&commands.NotProcessedError{GoAway: frame})
+		}
+	}
+	c.failQueuedRequests(&commands.NotProcessedError{GoAway: frame})
+}
+
+// After GOAWAY was received, or after a request was refused because the stream ids are used up, the connection is closed
+// as soon as the remaining streams are complete. Cached push promises that were not started by the server are not waited for.
+// The connection is not closed when the last id is used, because the client reconnects only when a request is refused.
+func (c *connection) closeIfGoAwayCompleted() {
+	if (c.goAway == nil && !c.refusedRequests) || c.isShutdown {
+		return
+	}
+	for _, s := range c.streams {
+		if !s.GetState().In(streamstate.IDLE, streamstate.RESERVED_REMOTE, streamstate.CLOSED) {
+			return
+		}
+	}
+	if c.goAway != nil {
+		c.close(fmt.Errorf("Server sent %v.", commands.DescribeGoAway(c.goAway)))
+	} else {
+		c.Write(frames.NewGoAwayFrame(0, c.highestPeerStreamId, frames.NO_ERROR))
+		c.close(fmt.Errorf("All stream identifiers of the connection were used up."))
+	}
+}
+
+// HandleFrameError responds to a received frame that could not be decoded because it violates RFC 7540.
+// Connection errors are handled with GOAWAY, stream errors with RST_STREAM, see RFC 7540 section 5.4.
+func (c *connection) HandleFrameError(err *frames.FrameError) {
+	if err.IsConnectionError {
+		c.connectionError(err.ErrorCode, err.Message)
+		return
+	}
+	stream, exists := c.getStreamIfExists(err.StreamId)
+	if exists {
+		stream.CloseWithError(err.ErrorCode, err.Message)
+	} else {
+		c.Write(frames.NewRstStreamFrame(err.StreamId, err.ErrorCode))
+	}
+}
+
+// connectionError closes the connection with a GOAWAY frame carrying errorCode and msg as debug data,
+// see RFC 7540 section 5.4.1. Requests that did not complete yet fail with a commands.ConnectionError.
 func (c *connection) connectionError(errorCode frames.ErrorCode, msg string) {
-	// TODO:
-	//   * Find highest stream id that was successfully processed
-	//   * Send GO_AWAY frame with error code (maybe msg as additional debug data)
-	//   * Shut down connection
-	fmt.Fprintf(os.Stderr, "%v Should send GOAWAY frame with error code %v, but this is not implemented yet.\n", msg, errorCode)
+	if c.isShutdown {
+		return
+	}
+	goAway := frames.NewGoAwayFrame(0, c.highestPeerStreamId, errorCode)
+	goAway.AdditionalDebugData = []byte(msg)
+	c.Write(goAway)
+	c.close(&commands.ConnectionError{ErrorCode: errorCode, Message: msg})
 }
 
 func (c *connection) handleFrameForStream(frame frames.Frame) {
@@ -266,6 +678,10 @@ func (c *connection) handleFrameForStream(frame frames.Frame) {
 		c.handleIncomingDataFrame(frame)
 	case *frames.RstStreamFrame:
 		c.handleIncomingRstStreamFrame(frame)
+	case *frames.AltSvcFrame:
+		c.handleAltSvcFrame(frame)
+	case *frames.OriginFrame:
+		// RFC 8336 section 2.1: ORIGIN frames on streams other than 0 must be ignored.
 	default:
 		c.getOrCreateStream(frame.GetStreamId()).ReceiveFrame(frame)
 	}
@@ -295,7 +711,10 @@ func (c *connection) handleIncomingPushPromiseFrame(frame *frames.PushPromiseFra
 		c.connectionError(frames.PROTOCOL_ERROR, fmt.Sprintf("Received %v frame for associated stream in state %v.", frame.Type(), associatedStream.GetState()))
 		return
 	}
-	promisedStream := c.getOrCreateStream(frame.PromisedStreamId)
+	promisedStream := c.getOrCreateStream(frame.PromisedStreamId) // before updating highestPeerStreamId, see getOrCreateStream()
+	if frame.PromisedStreamId > c.highestPeerStreamId {
+		c.highestPeerStreamId = frame.PromisedStreamId
+	}
 	promisedStream.ReceiveFrame(frame)
 	method := findHeader(":method", frame.Headers)
 	if method != "GET" {
@@ -305,6 +724,51 @@ func (c *connection) handleIncomingPushPromiseFrame(frame *frames.PushPromiseFra
 	c.promisedStreamCache[promisedStream.StreamId()] = promisedStream
 }
 
+// RFC 7838 section 4: Invalid ALTSVC frames must be ignored.
+// On stream 0, the frame contains the origin. On other streams, the origin of the stream is used.
+func (c *connection) handleAltSvcFrame(frame *frames.AltSvcFrame) {
+	if !frame.IsValid() {
+		return
+	}
+	o := frame.Origin
+	if frame.StreamId != 0 {
+		stream, exists := c.getStreamIfExists(frame.StreamId)
+		if !exists {
+			return
+		}
+		o = findHeader(":scheme", stream.RequestHeaders()) + "://" + findHeader(":authority", stream.RequestHeaders())
+	}
+	if frame.Value == "clear" {
+		delete(c.alternativeServices, o)
+	} else {
+		c.alternativeServices[o] = frame.Value
+	}
+}
+
+// RFC 8336 section 2.3: Add the origins to the origin set.
+// RFC 8336 section 2.3: The Origin Set is initialized when the first ORIGIN frame is received.
+// The initial origin has the scheme "https", the host sent with SNI, and the port of the server.
+func (c *connection) handleOriginFrame(frame *frames.OriginFrame) {
+	if c.originSet == nil {
+		host := c.info.serverName
+		if host == "" {
+			host = c.host()
+		}
+		c.originSet = map[string]bool{origin("https", host, c.port()): true}
+	}
+	for _, o := range frame.Origins {
+		c.originSet[o] = true
+	}
+}
+
+// "https", "localhost", 8443 -> "https://localhost:8443"
+func origin(scheme string, host string, port int) string {
+	if (scheme == "https" && port == 443) || (scheme == "http" && port == 80) {
+		return fmt.Sprintf("%v://%v", scheme, host)
+	}
+	return fmt.Sprintf("%v://%v:%v", scheme, host, port)
+}
+
 func findHeader(name string, headers []hpack.HeaderField) string {
 	for _, header := range headers {
 		if header.Name == name {
@@ -314,48 +778,178 @@ func findHeader(name string, headers []hpack.HeaderField) string {
 	return ""
 }
 
-// Just a quick implementation to make large downloads work.
-// Should be replaced with a more sophisticated flow control strategy
-// TODO: This is copy-and-paste from connection
 func (c *connection) flowControlForIncomingDataFrame(frame *frames.DataFrame) {
-	threshold := int64(2 << 13) // size of one frame
-	c.remainingReceiveWindowSize -= int64(len(frame.Data))
-	if c.remainingReceiveWindowSize < threshold {
-		diff := int64(2<<15-1) - c.remainingReceiveWindowSize
-		c.remainingReceiveWindowSize += diff
-		c.Write(frames.NewWindowUpdateFrame(0, uint32(diff)))
+	c.remainingReceiveWindowSize -= int64(frame.PayloadLength())
+	if _, isTuner := c.receivePolicy.(flowcontrol.Tuner); isTuner {
+		c.measureRoundTrip(int64(frame.PayloadLength()))
+	}
+	increment := c.receivePolicy.WindowUpdate(c.receiveWindowSize, c.remainingReceiveWindowSize)
+	if increment > 0 {
+		c.remainingReceiveWindowSize += increment
+		c.Write(frames.NewWindowUpdateFrame(0, uint32(increment)))
+	}
+}
+
+func (c *connection) ReceiveFlowControlPolicy() flowcontrol.Policy {
+	return c.receivePolicy
+}
+
+// measureRoundTrip sends a PING frame when DATA is received and no PING is in flight.
+// The DATA received until the PING is acknowledged is an estimate of the bandwidth-delay product.
+func (c *connection) measureRoundTrip(nBytesReceived int64) {
+	if c.bdpPing != nil {
+		c.bdpPing.bytesPerRoundTrip += nBytesReceived
+		return
+	}
+	c.bdpPing = &bdpPing{
+		sentAt:            time.Now(),
+		bytesPerRoundTrip: nBytesReceived,
+	}
+	c.Write(frames.NewPingFrame(0, BDP_PING_PAYLOAD, false))
+}
+
+func (c *connection) handleBdpPingAck() {
+	if c.bdpPing == nil {
+		return
 	}
+	tuner, isTuner := c.receivePolicy.(flowcontrol.Tuner)
+	if isTuner {
+		newSize := tuner.Tune(c.receiveWindowSize, c.bdpPing.bytesPerRoundTrip, time.Since(c.bdpPing.sentAt))
+		if newSize > c.receiveWindowSize {
+			c.growReceiveWindow(newSize)
+			c.growInitialReceiveWindowOfStreams(uint32(newSize))
+		}
+	}
+	c.bdpPing = nil
+}
+
+// growReceiveWindow increases the connection's receive window with a WINDOW_UPDATE frame.
+func (c *connection) growReceiveWindow(newSize int64) {
+	delta := newSize - c.receiveWindowSize
+	c.receiveWindowSize = newSize
+	c.remainingReceiveWindowSize += delta
+	c.Write(frames.NewWindowUpdateFrame(0, uint32(delta)))
+}
+
+// growInitialReceiveWindowOfStreams sends SETTINGS_INITIAL_WINDOW_SIZE, which increases the receive windows of all streams.
+func (c *connection) growInitialReceiveWindowOfStreams(newSize uint32) {
+	delta := int64(newSize) - int64(c.settings.initialReceiveWindowSizeForNewStreams)
+	c.settings.initialReceiveWindowSizeForNewStreams = newSize
+	for _, s := range c.streams {
+		if !s.GetState().In(streamstate.CLOSED) {
+			s.AdjustReceiveFlowControlWindow(delta)
+		}
+	}
+	settingsFrame := frames.NewSettingsFrame(0, false)
+	settingsFrame.Settings[frames.SETTINGS_INITIAL_WINDOW_SIZE] = newSize
+	c.Write(settingsFrame)
 }
 
 func (c *connection) handleSettingsFrame(frame *frames.SettingsFrame) {
+	if frame.Ack {
+		if len(c.settingsAckDeadlines) > 0 {
+			c.settingsAckDeadlines = c.settingsAckDeadlines[1:]
+		}
+		return
+	}
+	if frames.SETTINGS_ENABLE_PUSH.IsSet(frame) && frames.SETTINGS_ENABLE_PUSH.Get(frame) != 0 {
+		// RFC 9113 section 6.5.2: If a server sends SETTINGS_ENABLE_PUSH, the value must be 0.
+		c.connectionError(frames.PROTOCOL_ERROR, fmt.Sprintf("Received %v with value %v from the server.", frames.SETTINGS_ENABLE_PUSH, frames.SETTINGS_ENABLE_PUSH.Get(frame)))
+		return
+	}
 	if frames.SETTINGS_MAX_FRAME_SIZE.IsSet(frame) {
 		c.settings.serverFrameSize = (frames.SETTINGS_MAX_FRAME_SIZE.Get(frame))
+		c.encodingContext.SetMaxFrameSize(c.settings.serverFrameSize)
 	}
+	windowSizeChanged := false
 	if frames.SETTINGS_INITIAL_WINDOW_SIZE.IsSet(frame) {
-		// TODO: This only covers the INITIAL_WINDOW_SIZE setting in the connection preface phase.
-		// TODO: Once the connection is established, the following needs to be implemented:
-		// TODO: When the value of SETTINGS_INITIAL_WINDOW_SIZE changes,
-		// TODO: a receiver MUST adjust the size of all stream flow-control windows that it maintains
-		// TODO: by the difference between the new value and the old value.
-		// TODO: See Section 6.9.2 in the spec.
-		c.settings.initialSendWindowSizeForNewStreams = frames.SETTINGS_INITIAL_WINDOW_SIZE.Get(frame)
+		// RFC 7540 section 6.9.2: The difference between the new and the old value applies to all open streams.
+		newSize := frames.SETTINGS_INITIAL_WINDOW_SIZE.Get(frame)
+		delta := int64(newSize) - int64(c.settings.initialSendWindowSizeForNewStreams)
+		c.settings.initialSendWindowSizeForNewStreams = newSize
+		for _, s := range c.streams {
+			if s.GetState().In(streamstate.CLOSED) {
+				continue
+			}
+			if err := s.AdjustSendFlowControlWindow(delta); err != nil {
+				c.connectionError(frames.FLOW_CONTROL_ERROR, err.Error())
+				return
+			}
+		}
+		windowSizeChanged = delta > 0
+	}
+	if frames.SETTINGS_HEADER_TABLE_SIZE.IsSet(frame) {
+		c.encodingContext.SetHeaderTableSize(frames.SETTINGS_HEADER_TABLE_SIZE.Get(frame))
+	}
+	if frames.SETTINGS_MAX_HEADER_LIST_SIZE.IsSet(frame) {
+		c.settings.serverMaxHeaderListSize = frames.SETTINGS_MAX_HEADER_LIST_SIZE.Get(frame)
+	}
+	if frames.SETTINGS_MAX_CONCURRENT_STREAMS.IsSet(frame) {
+		// Streams exceeding a reduced limit remain open, see RFC 7540 section 5.1.2.
+		c.settings.serverMaxConcurrentStreams = frames.SETTINGS_MAX_CONCURRENT_STREAMS.Get(frame)
+	}
+	// Settings with unknown identifiers are ignored, see RFC 7540 section 6.5.2.
+	c.Write(frames.NewSettingsFrame(0, true))
+	if windowSizeChanged {
+		c.sendPendingDataFrames()
 	}
-	// TODO: Implement other settings, like HEADER_TABLE_SIZE.
-	// TODO: Send PROTOCOL_ERROR if ACK is set but length > 0
-	if !frame.Ack {
-		c.Write(frames.NewSettingsFrame(0, true))
+	c.startQueuedRequests()
+}
+
+// CheckTimeouts is called periodically by the event loop.
+func (c *connection) CheckTimeouts(now time.Time) {
+	if len(c.settingsAckDeadlines) > 0 && now.After(c.settingsAckDeadlines[0]) {
+		c.connectionError(frames.SETTINGS_TIMEOUT, fmt.Sprintf("The server did not acknowledge SETTINGS within %v.", SETTINGS_ACK_TIMEOUT))
 	}
 }
 
 func (c *connection) handleWindowUpdateFrame(frame *frames.WindowUpdateFrame) {
+	if c.remainingSendWindowSize+int64(frame.WindowSizeIncrement) > flowcontrol.MAX_WINDOW_SIZE {
+		// RFC 7540 section 6.9.1: The connection's window must not exceed 2^31-1.
+		c.connectionError(frames.FLOW_CONTROL_ERROR, fmt.Sprintf("%v with increment %v would increase the connection's flow-control window to %v.", frame.Type(), frame.WindowSizeIncrement, c.remainingSendWindowSize+int64(frame.WindowSizeIncrement)))
+		return
+	}
 	c.increaseSendFlowControlWindow(int64(frame.WindowSizeIncrement))
-	for _, s := range c.streams {
-		s.ProcessPendingDataFrames()
+	c.sendPendingDataFrames()
+}
+
+func (c *connection) SchedulePendingDataFrames(s stream.Stream) {
+	for _, pending := range c.pendingDataStreams {
+		if pending == s {
+			c.sendPendingDataFrames()
+			return
+		}
+	}
+	c.pendingDataStreams = append(c.pendingDataStreams, s)
+	c.sendPendingDataFrames()
+}
+
+// sendPendingDataFrames sends one DATA frame per stream and round, so that streams blocked on the connection's
+// flow-control window share it fairly. It stops when no stream in the queue can send anything.
+func (c *connection) sendPendingDataFrames() {
+	nBlocked := 0
+	for nBlocked < len(c.pendingDataStreams) {
+		s := c.pendingDataStreams[0]
+		c.pendingDataStreams = c.pendingDataStreams[1:]
+		if s.SendNextPendingDataFrame() {
+			nBlocked = 0
+		} else {
+			nBlocked++
+		}
+		if s.HasPendingDataFrames() {
+			c.pendingDataStreams = append(c.pendingDataStreams, s)
+		} else if nBlocked > 0 {
+			nBlocked--
+		}
 	}
 }
 
-func (c *connection) RemainingSendFlowControlWindowIsEnough(nBytesToWrite int64) bool {
-	return c.remainingSendWindowSize > nBytesToWrite
+func (c *connection) RemainingSendFlowControlWindow() int64 {
+	return c.remainingSendWindowSize
+}
+
+func (c *connection) MaxFrameSize() uint32 {
+	return c.settings.serverFrameSize
 }
 
 func (c *connection) DecreaseSendFlowControlWindow(nBytesToWrite int64) {
@@ -367,63 +961,61 @@ func (c *connection) increaseSendFlowControlWindow(nBytes int64) {
 }
 
 func (c *connection) Write(frame frames.Frame) {
-	encodedFrame, err := frame.Encode(c.encodingContext)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to encode frame: %v", err.Error())
-		os.Exit(-1)
+	if settingsFrame, isSettings := frame.(*frames.SettingsFrame); isSettings && !settingsFrame.Ack {
+		c.settingsAckDeadlines = append(c.settingsAckDeadlines, time.Now().Add(SETTINGS_ACK_TIMEOUT))
 	}
-	if c.outgoingFrameFilters != nil {
-		for _, filter := range c.outgoingFrameFilters {
-			frame = filter(frame)
-		}
-	}
-	_, err = c.conn.Write(encodedFrame)
+	err := c.writer.WriteFrame(frame)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to write frame: %v", err.Error())
+		fmt.Fprintf(os.Stderr, "%v\n", err.Error())
+	}
+}
+
+// The filters are called by the writer after the frame is encoded, so that they see the frames.HpackInfo.
+// Each CONTINUATION frame is passed to the filters, too.
+func (c *connection) filterOutgoingFrame(frame frames.Frame) frames.Frame {
+	for _, filter := range c.outgoingFrameFilters {
+		frame = filter(frame)
 	}
+	return frame
 }
 
+// Streams with ids lower than the ids in use are closed, see RFC 7540 section 5.1.1. They were removed from the streams map,
+// so a new stream in CLOSED state is returned for them. That stream is not added to the map.
 func (c *connection) getOrCreateStream(streamId uint32) stream.Stream {
 	result, exists := c.getStreamIfExists(streamId)
 	if !exists {
 		result = stream.New(streamId, nil, c.settings.initialSendWindowSizeForNewStreams, c.settings.initialReceiveWindowSizeForNewStreams, c)
-		c.streams[streamId] = result
+		if (streamId%2 == 1 && streamId < c.nextStreamId) || (streamId%2 == 0 && streamId <= c.highestPeerStreamId) {
+			result.SetState(streamstate.CLOSED)
+		} else {
+			c.streams[streamId] = result
+		}
 	}
 	return result
 }
 
+// StreamClosed moves the stream from the streams map to the closedStreams history.
+func (c *connection) StreamClosed(s stream.Stream) {
+	delete(c.streams, s.StreamId())
+	c.closedStreams = append(c.closedStreams, s)
+	if len(c.closedStreams) > MAX_CLOSED_STREAMS {
+		c.closedStreams = c.closedStreams[1:]
+	}
+	c.startQueuedRequests()
+}
+
 func (c *connection) getStreamIfExists(streamId uint32) (stream.Stream, bool) {
 	stream, exists := c.streams[streamId]
 	return stream, exists
 }
 
+// Streams initiated by the client must use odd-numbered stream identifiers, see RFC 7540 section 5.1.1.
+// The caller must check that nextStreamId does not exceed MAX_STREAM_ID.
 func (c *connection) newStream(cmd *commands.HttpCommand) stream.Stream {
-	// Streams initiated by the client must use odd-numbered stream identifiers.
-	streamIdsInUse := make([]uint32, len(c.streams))
-	for id, _ := range c.streams {
-		if id%2 == 1 {
-			streamIdsInUse = append(streamIdsInUse, id)
-		}
-	}
-	nextStreamId := uint32(1)
-	if len(streamIdsInUse) > 0 {
-		nextStreamId = max(streamIdsInUse) + 2
-	}
-	c.streams[nextStreamId] = stream.New(nextStreamId, cmd, c.settings.initialSendWindowSizeForNewStreams, c.settings.initialReceiveWindowSizeForNewStreams, c)
-	return c.streams[nextStreamId]
-}
-
-func max(numbers []uint32) uint32 {
-	if numbers == nil || len(numbers) == 0 {
-		return 0
-	}
-	result := numbers[0]
-	for _, n := range numbers {
-		if n > result {
-			result = n
-		}
-	}
-	return result
+	streamId := c.nextStreamId
+	c.nextStreamId += 2
+	c.streams[streamId] = stream.New(streamId, cmd, c.settings.initialSendWindowSizeForNewStreams, c.settings.initialReceiveWindowSizeForNewStreams, c)
+	return c.streams[streamId]
 }
 
 func (c *connection) serverFrameSize() uint32 {
@@ -446,28 +1038,23 @@ func (c *connection) error() error {
 	return c.err
 }
 
+// newReader creates a reader passing each frame to the incoming frame filters, including CONTINUATION frames.
+// The streams always get the complete header block, so CONTINUATION frames are merged after filtering.
+func (c *connection) newReader(in io.Reader) *frames.Reader {
+	reader := frames.NewReader(in, c.decodingContext)
+	reader.FilterFrames(c.filterIncomingFrame)
+	reader.ReassembleHeaderBlocks(true)
+	return reader
+}
+
+func (c *connection) filterIncomingFrame(frame frames.Frame) frames.Frame {
+	for _, filter := range c.incomingFrameFilters {
+		frame = filter(frame)
+	}
+	return frame
+}
+
 // TODO: This is called in another thread, which is confusing. Should have a different Handler for things that are not called from the event loop.
 func (c *connection) ReadNextFrame() (frames.Frame, error) {
-	headerData := make([]byte, 9) // Frame starts with a 9 Bytes header
-	_, err := io.ReadFull(c.conn, headerData)
-	if err != nil {
-		return nil, err
-	}
-	header := frames.DecodeHeader(headerData)
-	payload := make([]byte, header.Length)
-	_, err = io.ReadFull(c.conn, payload)
-	if err != nil {
-		return nil, err
-	}
-	decodeFunc := frames.FindDecoder(frames.Type(header.HeaderType))
-	if decodeFunc == nil {
-		return nil, fmt.Errorf("%v: Unknown frame type.", header.HeaderType)
-	}
-	frame, err := decodeFunc(header.Flags, header.StreamId, payload, c.decodingContext)
-	if c.incomingFrameFilters != nil {
-		for _, filter := range c.incomingFrameFilters {
-			frame = filter(frame)
-		}
-	}
-	return frame, err
+	return c.reader.ReadFrame()
 }
@@ -0,0 +1,697 @@
+package connection
+
+import (
+	"bytes"
+	"github.com/fstab/h2c/http2client/flowcontrol"
+	"github.com/fstab/h2c/http2client/frames"
+	"github.com/fstab/h2c/http2client/internal/eventloop/commands"
+	"golang.org/x/net/http2/hpack"
+	"io"
+	"io/ioutil"
+	"net"
+	neturl "net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestConnection creates a connection on a net.Pipe. The frames written by the connection are received on the channel.
+func newTestConnection(t *testing.T) (*connection, <-chan frames.Frame) {
+	client, server := net.Pipe()
+	c := newConnection(client, "http", "localhost", 80, nil, nil)
+	written := make(chan frames.Frame, 100)
+	go func() {
+		decodingContext := frames.NewDecodingContext()
+		decodingContext.SetMaxFrameSize(2<<23 - 1) // Accept any SETTINGS_MAX_FRAME_SIZE the test sends to the connection.
+		reader := frames.NewReader(server, decodingContext)
+		reader.ReassembleHeaderBlocks(true)
+		for {
+			frame, err := reader.ReadFrame()
+			if err != nil {
+				close(written)
+				return
+			}
+			written <- frame
+		}
+	}()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return c, written
+}
+
+func expectFrame(t *testing.T, written <-chan frames.Frame, frameType frames.Type) frames.Frame {
+	select {
+	case frame := <-written:
+		if frame == nil || frame.Type() != frameType {
+			t.Fatalf("Expected %v frame, but got %v.", frameType, frame)
+		}
+		return frame
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected %v frame, but no frame was written.", frameType)
+		return nil
+	}
+}
+
+func expectNoFrame(t *testing.T, written <-chan frames.Frame) {
+	select {
+	case frame := <-written:
+		t.Fatalf("Expected no frame, but got %v frame.", frame.Type())
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func expectGoAway(t *testing.T, written <-chan frames.Frame, errorCode frames.ErrorCode) {
+	goAway := expectFrame(t, written, frames.GOAWAY_TYPE).(*frames.GoAwayFrame)
+	if goAway.ErrorCode != errorCode {
+		t.Errorf("Expected GOAWAY with error code %v, but got %v.", errorCode, goAway.ErrorCode)
+	}
+}
+
+func receiveSettings(c *connection, settings map[frames.Setting]uint32) {
+	frame := frames.NewSettingsFrame(0, false)
+	for setting, value := range settings {
+		frame.Settings[setting] = value
+	}
+	c.HandleIncomingFrame(frame)
+}
+
+func newPostCommand(t *testing.T, bodySize int) *commands.HttpCommand {
+	url, err := neturl.Parse("http://localhost/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := commands.NewHttpCommand("POST", url)
+	cmd.Request.SetBody(make([]byte, bodySize), true)
+	return cmd
+}
+
+func expectDataFrame(t *testing.T, written <-chan frames.Frame, streamId uint32, length int, endStream bool) {
+	dataFrame := expectFrame(t, written, frames.DATA_TYPE).(*frames.DataFrame)
+	if dataFrame.StreamId != streamId || len(dataFrame.Data) != length || dataFrame.EndStream != endStream {
+		t.Errorf("Expected DATA frame for stream %v with %v bytes and END_STREAM %v, but got stream %v with %v bytes and END_STREAM %v.", streamId, length, endStream, dataFrame.StreamId, len(dataFrame.Data), dataFrame.EndStream)
+	}
+}
+
+func TestInitialWindowSizeIncrease(t *testing.T) {
+	c, written := newTestConnection(t)
+	receiveSettings(c, map[frames.Setting]uint32{frames.SETTINGS_INITIAL_WINDOW_SIZE: 10})
+	expectFrame(t, written, frames.SETTINGS_TYPE)
+	c.ExecuteHttpCommand(newPostCommand(t, 100))
+	expectFrame(t, written, frames.HEADERS_TYPE)
+	expectDataFrame(t, written, 1, 10, false) // Only 10 bytes fit into the stream's flow-control window.
+	expectNoFrame(t, written)
+	receiveSettings(c, map[frames.Setting]uint32{frames.SETTINGS_INITIAL_WINDOW_SIZE: 200})
+	expectFrame(t, written, frames.SETTINGS_TYPE)
+	expectDataFrame(t, written, 1, 90, true)
+}
+
+func TestInitialWindowSizeNegative(t *testing.T) {
+	c, written := newTestConnection(t)
+	receiveSettings(c, map[frames.Setting]uint32{frames.SETTINGS_INITIAL_WINDOW_SIZE: 16400})
+	expectFrame(t, written, frames.SETTINGS_TYPE)
+	c.ExecuteHttpCommand(newPostCommand(t, 16400+100))
+	expectFrame(t, written, frames.HEADERS_TYPE)
+	expectDataFrame(t, written, 1, 16384, false) // SETTINGS_MAX_FRAME_SIZE
+	expectDataFrame(t, written, 1, 16, false)
+	expectNoFrame(t, written)
+	// The stream's window becomes 0 - 16400 = -16400.
+	receiveSettings(c, map[frames.Setting]uint32{frames.SETTINGS_INITIAL_WINDOW_SIZE: 0})
+	expectFrame(t, written, frames.SETTINGS_TYPE)
+	c.HandleIncomingFrame(frames.NewWindowUpdateFrame(1, 16400))
+	expectNoFrame(t, written)
+	c.HandleIncomingFrame(frames.NewWindowUpdateFrame(1, 40))
+	expectDataFrame(t, written, 1, 40, false)
+}
+
+func TestInitialWindowSizeOverflow(t *testing.T) {
+	c, written := newTestConnection(t)
+	c.ExecuteHttpCommand(newPostCommand(t, 10))
+	expectFrame(t, written, frames.HEADERS_TYPE)
+	expectFrame(t, written, frames.DATA_TYPE)
+	c.HandleIncomingFrame(frames.NewWindowUpdateFrame(1, 2<<30-1-(2<<15-1)))
+	receiveSettings(c, map[frames.Setting]uint32{frames.SETTINGS_INITIAL_WINDOW_SIZE: 2<<15 + 100})
+	expectGoAway(t, written, frames.FLOW_CONTROL_ERROR)
+	if !c.IsShutdown() {
+		t.Error("Expected the connection to be closed.")
+	}
+}
+
+func TestMaxHeaderListSize(t *testing.T) {
+	c, written := newTestConnection(t)
+	receiveSettings(c, map[frames.Setting]uint32{frames.SETTINGS_MAX_HEADER_LIST_SIZE: 100})
+	expectFrame(t, written, frames.SETTINGS_TYPE)
+	cmd := newPostCommand(t, 0)
+	c.ExecuteHttpCommand(cmd)
+	err := cmd.AwaitCompletion(1)
+	if err == nil || !strings.Contains(err.Error(), frames.SETTINGS_MAX_HEADER_LIST_SIZE.String()) {
+		t.Errorf("Expected error because of %v, but got %v.", frames.SETTINGS_MAX_HEADER_LIST_SIZE, err)
+	}
+	expectNoFrame(t, written)
+}
+
+func TestEnablePushFromServer(t *testing.T) {
+	c, written := newTestConnection(t)
+	receiveSettings(c, map[frames.Setting]uint32{frames.SETTINGS_ENABLE_PUSH: 0})
+	expectFrame(t, written, frames.SETTINGS_TYPE)
+	receiveSettings(c, map[frames.Setting]uint32{frames.SETTINGS_ENABLE_PUSH: 1})
+	expectGoAway(t, written, frames.PROTOCOL_ERROR)
+}
+
+func TestSettingsTimeout(t *testing.T) {
+	c, written := newTestConnection(t)
+	c.Write(frames.NewSettingsFrame(0, false))
+	expectFrame(t, written, frames.SETTINGS_TYPE)
+	c.CheckTimeouts(time.Now())
+	c.HandleIncomingFrame(frames.NewSettingsFrame(0, true))
+	c.CheckTimeouts(time.Now().Add(2 * SETTINGS_ACK_TIMEOUT))
+	expectNoFrame(t, written)
+	c.Write(frames.NewSettingsFrame(0, false))
+	expectFrame(t, written, frames.SETTINGS_TYPE)
+	c.CheckTimeouts(time.Now().Add(2 * SETTINGS_ACK_TIMEOUT))
+	expectGoAway(t, written, frames.SETTINGS_TIMEOUT)
+}
+
+// newOpenStream sends a GET request and receives the response headers, so that DATA frames can be received on stream 1.
+func newOpenStream(t *testing.T, c *connection, written <-chan frames.Frame) {
+	url, err := neturl.Parse("http://localhost/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.ExecuteHttpCommand(commands.NewHttpCommand("GET", url))
+	expectFrame(t, written, frames.HEADERS_TYPE)
+	headersFrame := frames.NewHeadersFrame(1, []hpack.HeaderField{hpack.HeaderField{Name: ":status", Value: "200"}})
+	headersFrame.EndStream = false
+	c.HandleIncomingFrame(headersFrame)
+}
+
+func TestNeverUpdateWindow(t *testing.T) {
+	c, written := newTestConnection(t)
+	c.receivePolicy = flowcontrol.Never()
+	newOpenStream(t, c, written)
+	for i := 0; i < 4; i++ {
+		c.HandleIncomingFrame(frames.NewDataFrame(1, make([]byte, 16384), false))
+	}
+	expectNoFrame(t, written)
+}
+
+func TestThresholdUpdateWindow(t *testing.T) {
+	c, written := newTestConnection(t)
+	newOpenStream(t, c, written)
+	c.HandleIncomingFrame(frames.NewDataFrame(1, make([]byte, 40000), false))
+	expectNoFrame(t, written)
+	c.HandleIncomingFrame(frames.NewDataFrame(1, make([]byte, 10000), false))
+	// Less than 16384 bytes remain, so both windows are refilled.
+	for _, streamId := range []uint32{0, 1} {
+		windowUpdate := expectFrame(t, written, frames.WINDOW_UPDATE_TYPE).(*frames.WindowUpdateFrame)
+		if windowUpdate.StreamId != streamId || windowUpdate.WindowSizeIncrement != 50000 {
+			t.Errorf("Expected WINDOW_UPDATE with increment 50000 for stream %v, but got %v for stream %v.", streamId, windowUpdate.WindowSizeIncrement, windowUpdate.StreamId)
+		}
+	}
+}
+
+func TestBdpGrowsWindow(t *testing.T) {
+	c, written := newTestConnection(t)
+	c.receivePolicy = flowcontrol.Bdp()
+	newOpenStream(t, c, written)
+	c.HandleIncomingFrame(frames.NewDataFrame(1, make([]byte, 16384), false))
+	ping := expectFrame(t, written, frames.PING_TYPE).(*frames.PingFrame)
+	if ping.Payload != BDP_PING_PAYLOAD {
+		t.Fatalf("Expected PING with payload %x, but got %x.", BDP_PING_PAYLOAD, ping.Payload)
+	}
+	c.HandleIncomingFrame(frames.NewDataFrame(1, make([]byte, 16384), false))
+	c.HandleIncomingFrame(frames.NewDataFrame(1, make([]byte, 16384), false))
+	// Less than half of the window remains, so the window is refilled for the connection and the stream.
+	expectFrame(t, written, frames.WINDOW_UPDATE_TYPE)
+	expectFrame(t, written, frames.WINDOW_UPDATE_TYPE)
+	// 49152 bytes were received within one round trip, which is more than 2/3 of the window.
+	c.HandleIncomingFrame(frames.NewPingFrame(0, BDP_PING_PAYLOAD, true))
+	windowUpdate := expectFrame(t, written, frames.WINDOW_UPDATE_TYPE).(*frames.WindowUpdateFrame)
+	if windowUpdate.StreamId != 0 || windowUpdate.WindowSizeIncrement != 2*49152-65535 {
+		t.Errorf("Expected WINDOW_UPDATE for the connection with increment %v, but got %v for stream %v.", 2*49152-65535, windowUpdate.WindowSizeIncrement, windowUpdate.StreamId)
+	}
+	settings := expectFrame(t, written, frames.SETTINGS_TYPE).(*frames.SettingsFrame)
+	if frames.SETTINGS_INITIAL_WINDOW_SIZE.Get(settings) != 2*49152 {
+		t.Errorf("Expected %v %v, but got %v.", frames.SETTINGS_INITIAL_WINDOW_SIZE, 2*49152, frames.SETTINGS_INITIAL_WINDOW_SIZE.Get(settings))
+	}
+}
+
+func TestManualWindowUpdate(t *testing.T) {
+	c, written := newTestConnection(t)
+	c.receivePolicy = flowcontrol.Manual()
+	newOpenStream(t, c, written)
+	c.HandleIncomingFrame(frames.NewDataFrame(1, make([]byte, 60000), false))
+	expectNoFrame(t, written)
+	for _, test := range []struct {
+		streamId  uint32
+		increment uint32
+	}{
+		{0, 50000},
+		{1, 2<<30 - 1}, // Makes the window exceed 2^31-1, but sent anyway.
+		{99, 100},      // Stream does not exist, but sent anyway.
+	} {
+		cmd := commands.NewWindowUpdateCommand(test.streamId, test.increment)
+		c.ExecuteWindowUpdateCommand(cmd)
+		if err := cmd.AwaitCompletion(1); err != nil {
+			t.Fatal(err)
+		}
+		windowUpdate := expectFrame(t, written, frames.WINDOW_UPDATE_TYPE).(*frames.WindowUpdateFrame)
+		if windowUpdate.StreamId != test.streamId || windowUpdate.WindowSizeIncrement != test.increment {
+			t.Errorf("Expected WINDOW_UPDATE with increment %v for stream %v, but got %v for stream %v.", test.increment, test.streamId, windowUpdate.WindowSizeIncrement, windowUpdate.StreamId)
+		}
+	}
+	cmd := commands.NewMonitoringCommand()
+	c.ExecuteMonitoringCommand(cmd)
+	if cmd.Result.ConnectionInfo.ReceiveWindow != 65535-60000+50000 {
+		t.Errorf("Expected connection receive window %v, but got %v.", 65535-60000+50000, cmd.Result.ConnectionInfo.ReceiveWindow)
+	}
+	if cmd.Result.StreamInfo[0].ReceiveWindow != 65535-60000+2<<30-1 {
+		t.Errorf("Expected stream receive window %v, but got %v.", 65535-60000+2<<30-1, cmd.Result.StreamInfo[0].ReceiveWindow)
+	}
+}
+
+// 'h2c window update 0' sends a WINDOW_UPDATE frame with increment 0, which is invalid, see RFC 7540 section 6.9.
+// frames.Reader rejects it, so the raw bytes are checked.
+func TestManualWindowUpdateWithZeroIncrement(t *testing.T) {
+	client, server := net.Pipe()
+	c := newConnection(client, "http", "localhost", 80, nil, nil)
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	c.receivePolicy = flowcontrol.Manual()
+	cmd := commands.NewWindowUpdateCommand(0, 0)
+	go c.ExecuteWindowUpdateCommand(cmd)
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	raw := make([]byte, 13)
+	if _, err := io.ReadFull(server, raw); err != nil {
+		t.Fatal(err)
+	}
+	// Length 4, type WINDOW_UPDATE, no flags, stream 0, increment 0.
+	expected := []byte{0, 0, 4, byte(frames.WINDOW_UPDATE_TYPE), 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	if !bytes.Equal(raw, expected) {
+		t.Errorf("Expected %x, but got %x.", expected, raw)
+	}
+	if err := cmd.AwaitCompletion(1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDataFramesSplitToMaxFrameSize(t *testing.T) {
+	c, written := newTestConnection(t)
+	c.ExecuteHttpCommand(newPostCommand(t, 20000))
+	expectFrame(t, written, frames.HEADERS_TYPE)
+	expectDataFrame(t, written, 1, 16384, false)
+	expectDataFrame(t, written, 1, 20000-16384, true)
+	// SETTINGS_MAX_FRAME_SIZE applies to DATA frames sent after the change.
+	receiveSettings(c, map[frames.Setting]uint32{frames.SETTINGS_MAX_FRAME_SIZE: 20000})
+	expectFrame(t, written, frames.SETTINGS_TYPE)
+	c.ExecuteHttpCommand(newPostCommand(t, 20000))
+	expectFrame(t, written, frames.HEADERS_TYPE)
+	expectDataFrame(t, written, 3, 20000, true)
+}
+
+func TestDataFramesSplitToConnectionWindow(t *testing.T) {
+	c, written := newTestConnection(t)
+	receiveSettings(c, map[frames.Setting]uint32{frames.SETTINGS_INITIAL_WINDOW_SIZE: 100000})
+	expectFrame(t, written, frames.SETTINGS_TYPE)
+	c.ExecuteHttpCommand(newPostCommand(t, 70000))
+	expectFrame(t, written, frames.HEADERS_TYPE)
+	for _, length := range []int{16384, 16384, 16384, 16383} {
+		expectDataFrame(t, written, 1, length, false)
+	}
+	expectNoFrame(t, written) // The connection's window of 65535 bytes is used up.
+	c.HandleIncomingFrame(frames.NewWindowUpdateFrame(0, 65535))
+	expectDataFrame(t, written, 1, 70000-65535, true)
+}
+
+func TestDataFramesRoundRobin(t *testing.T) {
+	c, written := newTestConnection(t)
+	c.remainingSendWindowSize = 0
+	c.ExecuteHttpCommand(newPostCommand(t, 300))
+	c.ExecuteHttpCommand(newPostCommand(t, 300))
+	c.ExecuteHttpCommand(newPostCommand(t, 100))
+	for i := 0; i < 3; i++ {
+		expectFrame(t, written, frames.HEADERS_TYPE)
+	}
+	c.settings.serverFrameSize = 100 // Smaller than allowed by RFC 7540, but makes the rounds easy to see.
+	c.HandleIncomingFrame(frames.NewWindowUpdateFrame(0, 550))
+	expectDataFrame(t, written, 1, 100, false)
+	expectDataFrame(t, written, 3, 100, false)
+	expectDataFrame(t, written, 5, 100, true)
+	expectDataFrame(t, written, 1, 100, false)
+	expectDataFrame(t, written, 3, 100, false)
+	expectDataFrame(t, written, 1, 50, false)
+	expectNoFrame(t, written)
+	c.HandleIncomingFrame(frames.NewWindowUpdateFrame(0, 1000))
+	expectDataFrame(t, written, 3, 100, true)
+	expectDataFrame(t, written, 1, 50, true)
+}
+
+func TestStreamWindowUpdateOverflow(t *testing.T) {
+	c, written := newTestConnection(t)
+	cmd := newPostCommand(t, 10)
+	c.ExecuteHttpCommand(cmd)
+	expectFrame(t, written, frames.HEADERS_TYPE)
+	expectFrame(t, written, frames.DATA_TYPE)
+	c.HandleIncomingFrame(frames.NewWindowUpdateFrame(1, 2<<30-1-(2<<15-1)+10))
+	expectNoFrame(t, written)
+	c.HandleIncomingFrame(frames.NewWindowUpdateFrame(1, 1))
+	rstStream := expectFrame(t, written, frames.RST_STREAM_TYPE).(*frames.RstStreamFrame)
+	if rstStream.ErrorCode != frames.FLOW_CONTROL_ERROR {
+		t.Errorf("Expected RST_STREAM with %v, but got %v.", frames.FLOW_CONTROL_ERROR, rstStream.ErrorCode)
+	}
+	if err := cmd.AwaitCompletion(1); err == nil || !strings.Contains(err.Error(), "flow-control window") {
+		t.Errorf("Expected the request to fail because of the flow-control window, but got %v.", err)
+	}
+}
+
+func TestConnectionWindowUpdateOverflow(t *testing.T) {
+	c, written := newTestConnection(t)
+	c.HandleIncomingFrame(frames.NewWindowUpdateFrame(0, 2<<30-1-(2<<15-1)))
+	expectNoFrame(t, written)
+	c.HandleIncomingFrame(frames.NewWindowUpdateFrame(0, 1))
+	expectGoAway(t, written, frames.FLOW_CONTROL_ERROR)
+	if !c.IsShutdown() {
+		t.Error("Expected the connection to be closed.")
+	}
+}
+
+func newGetCommand(t *testing.T, path string) *commands.HttpCommand {
+	url, err := neturl.Parse("http://localhost" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return commands.NewHttpCommand("GET", url)
+}
+
+func TestMaxConcurrentStreams(t *testing.T) {
+	c, written := newTestConnection(t)
+	receiveSettings(c, map[frames.Setting]uint32{frames.SETTINGS_MAX_CONCURRENT_STREAMS: 1})
+	expectFrame(t, written, frames.SETTINGS_TYPE)
+	cmd1 := newGetCommand(t, "/1")
+	c.ExecuteHttpCommand(cmd1)
+	expectFrame(t, written, frames.HEADERS_TYPE)
+	c.ExecuteHttpCommand(newGetCommand(t, "/2"))
+	c.ExecuteHttpCommand(newGetCommand(t, "/3"))
+	expectNoFrame(t, written)
+	monitoringCmd := commands.NewMonitoringCommand()
+	c.ExecuteMonitoringCommand(monitoringCmd)
+	queued := monitoringCmd.Result.QueuedRequests
+	if len(queued) != 2 || queued[0].Path != "/2" || queued[1].Path != "/3" {
+		t.Errorf("Expected requests /2 and /3 to be queued, but got %v.", queued)
+	}
+	c.HandleIncomingFrame(frames.NewHeadersFrame(1, []hpack.HeaderField{hpack.HeaderField{Name: ":status", Value: "200"}}))
+	if err := cmd1.AwaitCompletion(1); err != nil {
+		t.Fatal(err)
+	}
+	headersFrame := expectFrame(t, written, frames.HEADERS_TYPE).(*frames.HeadersFrame)
+	if headersFrame.StreamId != 3 {
+		t.Errorf("Expected the first queued request on stream 3, but got stream %v.", headersFrame.StreamId)
+	}
+	expectNoFrame(t, written)
+	// Increasing the limit starts the remaining queued request.
+	receiveSettings(c, map[frames.Setting]uint32{frames.SETTINGS_MAX_CONCURRENT_STREAMS: 2})
+	expectFrame(t, written, frames.SETTINGS_TYPE)
+	expectFrame(t, written, frames.HEADERS_TYPE)
+}
+
+// A stream closed by the client must start queued requests without waiting for the next incoming frame.
+func TestQueuedRequestStartedWhenStreamClosedLocally(t *testing.T) {
+	c, written := newTestConnection(t)
+	receiveSettings(c, map[frames.Setting]uint32{frames.SETTINGS_MAX_CONCURRENT_STREAMS: 1})
+	expectFrame(t, written, frames.SETTINGS_TYPE)
+	cmd1 := newGetCommand(t, "/1")
+	c.ExecuteHttpCommand(cmd1)
+	expectFrame(t, written, frames.HEADERS_TYPE)
+	c.ExecuteHttpCommand(newGetCommand(t, "/2"))
+	expectNoFrame(t, written)
+	c.streams[1].CloseWithError(frames.CANCEL, "Cancelled.")
+	expectFrame(t, written, frames.RST_STREAM_TYPE)
+	headersFrame := expectFrame(t, written, frames.HEADERS_TYPE).(*frames.HeadersFrame)
+	if headersFrame.StreamId != 3 {
+		t.Errorf("Expected the queued request on stream 3, but got stream %v.", headersFrame.StreamId)
+	}
+}
+
+func TestTimedOutQueuedRequestIsNotSent(t *testing.T) {
+	c, written := newTestConnection(t)
+	receiveSettings(c, map[frames.Setting]uint32{frames.SETTINGS_MAX_CONCURRENT_STREAMS: 1})
+	expectFrame(t, written, frames.SETTINGS_TYPE)
+	c.ExecuteHttpCommand(newGetCommand(t, "/1"))
+	expectFrame(t, written, frames.HEADERS_TYPE)
+	cmd := newGetCommand(t, "/2")
+	c.ExecuteHttpCommand(cmd)
+	if err := cmd.AwaitCompletion(0); err == nil || !strings.Contains(err.Error(), "Timeout") {
+		t.Fatalf("Expected a timeout, but got %v.", err)
+	}
+	c.streams[1].CloseWithError(frames.CANCEL, "Cancelled.")
+	expectFrame(t, written, frames.RST_STREAM_TYPE)
+	expectNoFrame(t, written)
+	if len(c.queuedHttpCommands) != 0 {
+		t.Errorf("Expected the timed out request to be removed from the queue, but %v requests are queued.", len(c.queuedHttpCommands))
+	}
+}
+
+func TestTimedOutQueuedRequestsDoNotFillTheQueue(t *testing.T) {
+	c, written := newTestConnection(t)
+	receiveSettings(c, map[frames.Setting]uint32{frames.SETTINGS_MAX_CONCURRENT_STREAMS: 0})
+	expectFrame(t, written, frames.SETTINGS_TYPE)
+	for i := 0; i < MAX_QUEUED_REQUESTS; i++ {
+		cmd := newGetCommand(t, "/")
+		c.ExecuteHttpCommand(cmd)
+		cmd.AwaitCompletion(0)
+	}
+	cmd := newGetCommand(t, "/")
+	c.ExecuteHttpCommand(cmd)
+	if len(c.queuedHttpCommands) != 1 || c.queuedHttpCommands[0] != cmd {
+		t.Errorf("Expected only the last request to be queued, but %v requests are queued.", len(c.queuedHttpCommands))
+	}
+}
+
+func TestRequestQueueFull(t *testing.T) {
+	c, written := newTestConnection(t)
+	receiveSettings(c, map[frames.Setting]uint32{frames.SETTINGS_MAX_CONCURRENT_STREAMS: 0})
+	expectFrame(t, written, frames.SETTINGS_TYPE)
+	for i := 0; i < MAX_QUEUED_REQUESTS; i++ {
+		c.ExecuteHttpCommand(newGetCommand(t, "/"))
+	}
+	cmd := newGetCommand(t, "/")
+	c.ExecuteHttpCommand(cmd)
+	if err := cmd.AwaitCompletion(1); err == nil || !strings.Contains(err.Error(), "Too many requests") {
+		t.Errorf("Expected an error because the queue is full, but got %v.", err)
+	}
+	expectNoFrame(t, written)
+}
+
+func TestQueuedRequestsNotProcessedAfterGoAway(t *testing.T) {
+	c, written := newTestConnection(t)
+	receiveSettings(c, map[frames.Setting]uint32{frames.SETTINGS_MAX_CONCURRENT_STREAMS: 0})
+	expectFrame(t, written, frames.SETTINGS_TYPE)
+	cmd := newGetCommand(t, "/")
+	c.ExecuteHttpCommand(cmd)
+	c.HandleIncomingFrame(frames.NewGoAwayFrame(0, 0, frames.NO_ERROR))
+	if _, isNotProcessed := cmd.AwaitCompletion(1).(*commands.NotProcessedError); !isNotProcessed {
+		t.Error("Expected the queued request to fail with NotProcessedError.")
+	}
+}
+
+// completeRequest receives a response without body, so that the stream is closed.
+func completeRequest(t *testing.T, c *connection, cmd *commands.HttpCommand, streamId uint32) {
+	c.HandleIncomingFrame(frames.NewHeadersFrame(streamId, []hpack.HeaderField{hpack.HeaderField{Name: ":status", Value: "200"}}))
+	if err := cmd.AwaitCompletion(1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClosedStreamsAreRemoved(t *testing.T) {
+	c, written := newTestConnection(t)
+	for i := 0; i < MAX_CLOSED_STREAMS+5; i++ {
+		cmd := newGetCommand(t, "/")
+		c.ExecuteHttpCommand(cmd)
+		headersFrame := expectFrame(t, written, frames.HEADERS_TYPE).(*frames.HeadersFrame)
+		if headersFrame.StreamId != uint32(2*i+1) {
+			t.Fatalf("Expected stream id %v, but got %v.", 2*i+1, headersFrame.StreamId)
+		}
+		completeRequest(t, c, cmd, headersFrame.StreamId)
+	}
+	if len(c.streams) != 0 {
+		t.Errorf("Expected closed streams to be removed, but %v streams remain.", len(c.streams))
+	}
+	if len(c.closedStreams) != MAX_CLOSED_STREAMS || c.closedStreams[0].StreamId() != 11 {
+		t.Errorf("Expected the last %v closed streams starting with stream 11, but got %v streams.", MAX_CLOSED_STREAMS, len(c.closedStreams))
+	}
+	// Frames for removed streams are handled like frames for closed streams, not like frames for idle streams.
+	c.HandleIncomingFrame(frames.NewWindowUpdateFrame(1, 100))
+	c.HandleIncomingFrame(frames.NewRstStreamFrame(1, frames.CANCEL))
+	expectNoFrame(t, written)
+	if len(c.streams) != 0 || c.IsShutdown() {
+		t.Error("Expected frames for stream 1 to be ignored.")
+	}
+}
+
+func TestStreamIdsExhausted(t *testing.T) {
+	c, written := newTestConnection(t)
+	c.nextStreamId = MAX_STREAM_ID
+	cmd := newGetCommand(t, "/")
+	c.ExecuteHttpCommand(cmd)
+	headersFrame := expectFrame(t, written, frames.HEADERS_TYPE).(*frames.HeadersFrame)
+	if headersFrame.StreamId != MAX_STREAM_ID {
+		t.Fatalf("Expected stream id %v, but got %v.", MAX_STREAM_ID, headersFrame.StreamId)
+	}
+	exhaustedCmd := newGetCommand(t, "/")
+	c.ExecuteHttpCommand(exhaustedCmd)
+	if _, isExhausted := exhaustedCmd.AwaitCompletion(1).(*commands.StreamIdsExhaustedError); !isExhausted {
+		t.Error("Expected the request to fail with StreamIdsExhaustedError.")
+	}
+	expectNoFrame(t, written)
+	// The connection is closed when the last stream is complete.
+	completeRequest(t, c, cmd, MAX_STREAM_ID)
+	expectGoAway(t, written, frames.NO_ERROR)
+	if !c.IsShutdown() {
+		t.Error("Expected the connection to be closed.")
+	}
+}
+
+func TestUnknownSettingIsIgnored(t *testing.T) {
+	c, written := newTestConnection(t)
+	receiveSettings(c, map[frames.Setting]uint32{frames.Setting(0xf00d): 1})
+	if !expectFrame(t, written, frames.SETTINGS_TYPE).(*frames.SettingsFrame).Ack {
+		t.Error("Expected SETTINGS with ACK flag.")
+	}
+	expectNoFrame(t, written)
+	if c.IsShutdown() {
+		t.Error("Unknown settings must not close the connection.")
+	}
+}
+
+func TestIncomingFiltersSeeContinuationFrames(t *testing.T) {
+	client, server := net.Pipe()
+	filtered := make([]frames.Type, 0)
+	filter := func(frame frames.Frame) frames.Frame {
+		filtered = append(filtered, frame.Type())
+		return frame
+	}
+	c := newConnection(client, "http", "localhost", 80, []func(frames.Frame) frames.Frame{filter}, nil)
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	headersFrame := frames.NewHeadersFrame(1, []hpack.HeaderField{
+		hpack.HeaderField{Name: ":status", Value: "200"},
+		hpack.HeaderField{Name: "x-long-header", Value: strings.Repeat("x", 100)},
+	})
+	encodingContext := frames.NewEncodingContext()
+	encodingContext.SetMaxFrameSize(32) // split into CONTINUATION frames
+	encoded, err := headersFrame.Encode(encodingContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Write(encoded)
+	frame, err := c.ReadNextFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, isHeaders := frame.(*frames.HeadersFrame); !isHeaders || !result.EndHeaders || len(result.Headers) != 2 {
+		t.Errorf("Expected the complete header block, but got %v.", frame)
+	}
+	if len(filtered) < 2 || filtered[0] != frames.HEADERS_TYPE || filtered[len(filtered)-1] != frames.CONTINUATION_TYPE {
+		t.Errorf("Expected the filter to see %v and %v frames, but got %v.", frames.HEADERS_TYPE, frames.CONTINUATION_TYPE, filtered)
+	}
+}
+
+func TestOutgoingFiltersSeeContinuationFrames(t *testing.T) {
+	client, server := net.Pipe()
+	filtered := make([]frames.Type, 0)
+	filter := func(frame frames.Frame) frames.Frame {
+		filtered = append(filtered, frame.Type())
+		return frame
+	}
+	c := newConnection(client, "http", "localhost", 80, nil, []func(frames.Frame) frames.Frame{filter})
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	go ioutil.ReadAll(server)
+	c.encodingContext.SetMaxFrameSize(32) // split into CONTINUATION frames
+	c.Write(frames.NewHeadersFrame(1, []hpack.HeaderField{
+		hpack.HeaderField{Name: ":method", Value: "GET"},
+		hpack.HeaderField{Name: "x-long-header", Value: strings.Repeat("x", 100)},
+	}))
+	if len(filtered) < 2 || filtered[0] != frames.HEADERS_TYPE || filtered[len(filtered)-1] != frames.CONTINUATION_TYPE {
+		t.Errorf("Expected the filter to see %v and %v frames, but got %v.", frames.HEADERS_TYPE, frames.CONTINUATION_TYPE, filtered)
+	}
+}
+
+func TestOriginSetInitializedByFirstOriginFrame(t *testing.T) {
+	c, _ := newTestConnection(t)
+	cmd := commands.NewMonitoringCommand()
+	c.ExecuteMonitoringCommand(cmd)
+	if cmd.Result.ConnectionInfo.OriginSet != nil {
+		t.Errorf("Expected the origin set to be uninitialized, but got %v.", cmd.Result.ConnectionInfo.OriginSet)
+	}
+	c.info.serverName = "www.example.com"
+	c.HandleIncomingFrame(frames.NewOriginFrame(0, []string{"https://cdn.example.com"}))
+	cmd = commands.NewMonitoringCommand()
+	c.ExecuteMonitoringCommand(cmd)
+	expected := []string{"https://cdn.example.com", "https://www.example.com:80"}
+	if !reflect.DeepEqual(cmd.Result.ConnectionInfo.OriginSet, expected) {
+		t.Errorf("Expected origin set %v, but got %v.", expected, cmd.Result.ConnectionInfo.OriginSet)
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "h2c.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- conn
+	}()
+	conn, err := Start("http", "www.example.com", 8080, &Options{UnixSocket: socket}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := <-accepted
+	if server == nil {
+		t.Fatal("Failed to accept the connection on the unix socket.")
+	}
+	defer server.Close()
+	c := conn.(*connection)
+	defer c.Shutdown()
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err = io.ReadFull(server, make([]byte, len(CLIENT_PREFACE))); err != nil {
+		t.Fatal(err)
+	}
+	// The URL is completed with the connection's host and port, like http2client does for 'h2c get /'.
+	url, err := neturl.Parse(origin(c.info.scheme, c.host(), c.port()) + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.ExecuteHttpCommand(commands.NewHttpCommand("GET", url))
+	reader := frames.NewReader(server, frames.NewDecodingContext())
+	reader.ReassembleHeaderBlocks(true)
+	for {
+		frame, err := reader.ReadFrame()
+		if err != nil {
+			t.Fatalf("Expected %v frame, but got error: %v", frames.HEADERS_TYPE, err)
+		}
+		if headersFrame, isHeaders := frame.(*frames.HeadersFrame); isHeaders {
+			if authority := findHeader(":authority", headersFrame.Headers); authority != "www.example.com:8080" {
+				t.Errorf("Expected :authority www.example.com:8080, but got %v.", authority)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package connection
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestBypassProxy(t *testing.T) {
+	for _, test := range []struct {
+		host     string
+		noProxy  string
+		expected bool
+	}{
+		{"example.com", "", false},
+		{"example.com", "*", true},
+		{"example.com", "localhost, example.com", true},
+		{"www.example.com", "example.com", true},
+		{"www.example.com", ".example.com", true},
+		{"www.example.com", "*.example.com", true},
+		{"notexample.com", "example.com", false},
+		{"10.1.2.3", "10.0.0.0/8", true},
+		{"11.1.2.3", "10.0.0.0/8", false},
+		{"127.0.0.1", "127.0.0.1", true},
+		{"::1", "::1", true},
+	} {
+		if bypassProxy(test.host, test.noProxy) != test.expected {
+			t.Errorf("Expected bypassProxy(%v, %v) to be %v.", test.host, test.noProxy, test.expected)
+		}
+	}
+}
+
+// startConnectProxy accepts a single CONNECT request and tunnels it to the requested address.
+func startConnectProxy(t *testing.T, expectedAuthorization string) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		request, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil || request.Method != "CONNECT" {
+			io.WriteString(conn, "HTTP/1.1 400 Bad Request\r\n\r\n")
+			return
+		}
+		if request.Header.Get("Proxy-Authorization") != expectedAuthorization {
+			io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+			return
+		}
+		target, err := net.Dial("tcp", request.Host)
+		if err != nil {
+			io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+			return
+		}
+		defer target.Close()
+		io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		go io.Copy(target, conn)
+		io.Copy(conn, target)
+	}()
+	return listener
+}
+
+func startGreetingServer(t *testing.T, greeting string) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		io.WriteString(conn, greeting)
+		conn.Close()
+	}()
+	return listener
+}
+
+func TestHttpConnectProxy(t *testing.T) {
+	server := startGreetingServer(t, "hello")
+	defer server.Close()
+	proxy := startConnectProxy(t, "Basic dXNlcjpwYXNz")
+	defer proxy.Close()
+	conn, err := dial("127.0.0.1", server.Addr().String(), "http://user:pass@"+proxy.Addr().String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	data, err := ioutil.ReadAll(conn)
+	if err != nil || string(data) != "hello" {
+		t.Errorf("Expected to read 'hello' through the tunnel, but got '%v' (error: %v).", string(data), err)
+	}
+}
+
+func TestHttpConnectProxyAuthenticationRequired(t *testing.T) {
+	proxy := startConnectProxy(t, "Basic dXNlcjpwYXNz")
+	defer proxy.Close()
+	_, err := dial("example.com", "example.com:443", proxy.Addr().String(), "")
+	if err == nil || !strings.Contains(err.Error(), "407") {
+		t.Errorf("Expected error with status 407, but got %v.", err)
+	}
+}
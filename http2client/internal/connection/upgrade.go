@@ -0,0 +1,80 @@
+package connection
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"github.com/fstab/h2c/http2client/frames"
+	"github.com/fstab/h2c/http2client/internal/stream"
+	"golang.org/x/net/http2/hpack"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// upgrade switches a cleartext connection from HTTP/1.1 to HTTP/2 using the Upgrade: h2c header, see RFC 7540 section 3.2.
+// The payload of settingsFrame is sent in the HTTP2-Settings header. The client preface must be sent afterwards.
+//
+// The upgrade request becomes stream 1 in state half closed (local), and the server sends the response on that stream.
+func (c *connection) upgrade(settingsFrame *frames.SettingsFrame) error {
+	encodedSettings, err := settingsFrame.Encode(c.encodingContext)
+	if err != nil {
+		return fmt.Errorf("Failed to encode %v frame: %v", settingsFrame.Type(), err.Error())
+	}
+	authority := origin(c.info.scheme, c.host(), c.port())[len(c.info.scheme+"://"):]
+	request := "GET / HTTP/1.1\r\n" +
+		"Host: " + authority + "\r\n" +
+		"Connection: Upgrade, HTTP2-Settings\r\n" +
+		"Upgrade: h2c\r\n" +
+		"HTTP2-Settings: " + base64.RawURLEncoding.EncodeToString(encodedSettings[9:]) + "\r\n" +
+		"\r\n"
+	_, err = io.WriteString(c.conn, request)
+	if err != nil {
+		return fmt.Errorf("Failed to write upgrade request to %v: %v", authority, err.Error())
+	}
+	// The server may send its connection preface right after the 101 response,
+	// so frames must be read from the same buffered reader as the response.
+	in := bufio.NewReader(c.conn)
+	response, err := http.ReadResponse(in, nil)
+	if err != nil {
+		return fmt.Errorf("Failed to read response to upgrade request from %v: %v", authority, err.Error())
+	}
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		return refusedUpgradeError(response)
+	}
+	if !strings.EqualFold(response.Header.Get("Upgrade"), "h2c") {
+		return fmt.Errorf("Server switched to protocol '%v' instead of h2c.", response.Header.Get("Upgrade"))
+	}
+	c.reader = c.newReader(in)
+	requestHeaders := []hpack.HeaderField{
+		hpack.HeaderField{Name: ":method", Value: "GET"},
+		hpack.HeaderField{Name: ":scheme", Value: c.info.scheme},
+		hpack.HeaderField{Name: ":authority", Value: authority},
+		hpack.HeaderField{Name: ":path", Value: "/"},
+	}
+	c.streams[1] = stream.NewUpgradeStream(requestHeaders, c.settings.initialSendWindowSizeForNewStreams, c.settings.initialReceiveWindowSizeForNewStreams, c)
+	c.nextStreamId = 3
+	return nil
+}
+
+func refusedUpgradeError(response *http.Response) error {
+	defer response.Body.Close()
+	msg := fmt.Sprintf("Server refused to upgrade to h2c. Response:\n%v %v", response.Proto, response.Status)
+	names := make([]string, 0, len(response.Header))
+	for name := range response.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range response.Header[name] {
+			msg += fmt.Sprintf("\n%v: %v", name, value)
+		}
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1024))
+	if len(body) > 0 {
+		msg += "\n\n" + string(body)
+	}
+	return fmt.Errorf("%v", msg)
+}
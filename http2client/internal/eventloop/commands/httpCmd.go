@@ -2,16 +2,66 @@ package commands
 
 import (
 	"errors"
+	"fmt"
+	"github.com/fstab/h2c/http2client/frames"
 	"github.com/fstab/h2c/http2client/internal/util"
 	"golang.org/x/net/http2/hpack"
 	neturl "net/url"
 	"strconv"
+	"sync/atomic"
 )
 
 type HttpCommand struct {
-	Request  *httpMsg
-	Response *httpMsg
-	callback *util.AsyncTask
+	Request   *httpMsg
+	Response  *httpMsg
+	Padded    bool  // Send HEADERS and DATA frames with the PADDED flag.
+	PadLength uint8 // Number of padding bytes, only used if Padded is true.
+	Priority  bool  // Send the HEADERS frame with the PRIORITY flag.
+	// The following fields are only used if Priority is true.
+	StreamDependency uint32
+	Exclusive        bool
+	Weight           uint8 // Priority weight minus one, as in frames.HeadersFrame.
+	callback         *util.AsyncTask
+	cancelled        int32 // accessed atomically, because AwaitCompletion() is not called in the event loop's go routine.
+}
+
+// NotProcessedError means that the server did not process the request, because it sent a GOAWAY frame
+// with a last stream id lower than the request's stream id. It is safe to retry the request on a new connection,
+// see RFC 7540 section 8.1.4.
+type NotProcessedError struct {
+	GoAway *frames.GoAwayFrame
+}
+
+func (err *NotProcessedError) Error() string {
+	return fmt.Sprintf("The request was not processed, because the server sent %v. It is safe to retry the request on a new connection.", DescribeGoAway(err.GoAway))
+}
+
+// StreamIdsExhaustedError means that the request was not sent, because the client used up all stream identifiers
+// of the connection, see RFC 7540 section 5.1.1. The request can be sent on a new connection.
+type StreamIdsExhaustedError struct{}
+
+func (err *StreamIdsExhaustedError) Error() string {
+	return "The request was not sent, because all stream identifiers of the connection are used up. It can be sent on a new connection."
+}
+
+// ConnectionError means that h2c detected a protocol violation by the server, sent a GOAWAY frame
+// with ErrorCode and Message as debug data, and closed the connection, see RFC 7540 section 5.4.1.
+type ConnectionError struct {
+	ErrorCode frames.ErrorCode
+	Message   string
+}
+
+func (err *ConnectionError) Error() string {
+	return fmt.Sprintf("Connection error: %v Sent GOAWAY with error code %v and closed the connection.", err.Message, err.ErrorCode)
+}
+
+// "GOAWAY with error code NO_ERROR (last stream id 3, debug data "shutting down")"
+func DescribeGoAway(frame *frames.GoAwayFrame) string {
+	result := fmt.Sprintf("%v with error code %v (last stream id %v", frame.Type(), frame.ErrorCode, frame.LastStreamId)
+	if len(frame.AdditionalDebugData) > 0 {
+		result = result + fmt.Sprintf(", debug data %q", frame.AdditionalDebugData)
+	}
+	return result + ")"
 }
 
 type httpMsg struct {
@@ -43,6 +93,10 @@ func (m *httpMsg) AddHeader(name, value string) {
 	m.headers = append(m.headers, hpack.HeaderField{Name: name, Value: value})
 }
 
+func (m *httpMsg) AddHeaderField(field hpack.HeaderField) {
+	m.headers = append(m.headers, field)
+}
+
 func (m *httpMsg) GetHeaders() []hpack.HeaderField {
 	return m.headers
 }
@@ -75,6 +129,12 @@ func (c *HttpCommand) CompleteSuccessfully() {
 	c.callback.CompleteSuccessfully()
 }
 
+// IsCancelled is true if AwaitCompletion() returned an error, like a timeout. Nobody waits for the response anymore,
+// so a request that was not sent yet should not be sent.
+func (c *HttpCommand) IsCancelled() bool {
+	return atomic.LoadInt32(&c.cancelled) != 0
+}
+
 // If AwaitCompletion returns an error, this means that no HTTP response was received.
 // If an HTTP response is received, and this response has an error code (like 500),
 // AwaitCompletion will not return an error (the HTTP error code is treated like a regular HTTP response).
@@ -85,6 +145,7 @@ func (c *HttpCommand) CompleteSuccessfully() {
 func (c *HttpCommand) AwaitCompletion(timeoutInSeconds int) error {
 	err := c.callback.WaitForCompletion(timeoutInSeconds)
 	if err != nil {
+		atomic.StoreInt32(&c.cancelled, 1)
 		return err
 	}
 	if c.Response == nil {
@@ -6,15 +6,36 @@ import (
 	"sort"
 )
 
-// As of now, the "monitoring" is just use to retrieve info about stream states.
-// However, it could be extended to retrieve other info, like available window sizes, response times, etc.
+// The "monitoring" is used to retrieve info about stream states and the connection.
+// It could be extended to retrieve other info, like available window sizes, response times, etc.
 type MonitoringCommand struct {
 	Result   *monitoringCommandResult
 	callback *util.AsyncTask
 }
 
 type monitoringCommandResult struct {
-	StreamInfo sortableStreamInfoSlice
+	StreamInfo     sortableStreamInfoSlice
+	QueuedRequests []QueuedRequest // Requests waiting because of the server's SETTINGS_MAX_CONCURRENT_STREAMS, in the order they will be sent.
+	ConnectionInfo *ConnectionInfo
+}
+
+type ConnectionInfo struct {
+	Origin              string
+	UnixSocket          string               // Path of the unix domain socket, empty for TCP connections.
+	OriginSet           []string             // RFC 8336: Origins received in ORIGIN frames, nil if the Origin Set is not initialized.
+	AlternativeServices []AlternativeService // RFC 7838: Alternative services received in ALTSVC frames.
+	Tls                 bool
+	TlsVerified         bool     // false if the certificate was not verified (insecure).
+	TlsChain            []string // Summary of the server's certificate chain, starting with the server's certificate.
+	GoAway              string   // Description of the GOAWAY frame received from the server, empty if none was received.
+	SendWindow          int64    // Remaining flow-control window for sending DATA to the server.
+	ReceiveWindow       int64    // Remaining flow-control window for receiving DATA from the server.
+	ReceiveFlowControl  string   // Name of the policy deciding when WINDOW_UPDATE frames are sent.
+}
+
+type AlternativeService struct {
+	Origin string
+	Value  string
 }
 
 type sortableStreamInfoSlice []StreamInfo
@@ -25,6 +46,13 @@ type StreamInfo struct {
 	Path                string
 	State               streamstate.StreamState
 	IsCachedPushPromise bool
+	SendWindow          int64
+	ReceiveWindow       int64
+}
+
+type QueuedRequest struct {
+	HttpMethod string
+	Path       string
 }
 
 func NewMonitoringCommand() *MonitoringCommand {
@@ -36,21 +64,63 @@ func NewMonitoringCommand() *MonitoringCommand {
 
 func newMonitoringCommandResult() *monitoringCommandResult {
 	return &monitoringCommandResult{
-		StreamInfo: make([]StreamInfo, 0),
+		StreamInfo:     make([]StreamInfo, 0),
+		QueuedRequests: make([]QueuedRequest, 0),
+		ConnectionInfo: &ConnectionInfo{
+			AlternativeServices: make([]AlternativeService, 0),
+		},
 	}
 }
 
-func (res *monitoringCommandResult) AddStreamInfo(streamID uint32, httpMethod string, path string, state streamstate.StreamState, isCachedPushPromise bool) {
+func (res *monitoringCommandResult) SetOrigin(origin string) {
+	res.ConnectionInfo.Origin = origin
+}
+
+func (res *monitoringCommandResult) SetTlsInfo(verified bool, chain []string) {
+	res.ConnectionInfo.Tls = true
+	res.ConnectionInfo.TlsVerified = verified
+	res.ConnectionInfo.TlsChain = chain
+}
+
+func (res *monitoringCommandResult) SetFlowControlInfo(sendWindow int64, receiveWindow int64, receiveFlowControl string) {
+	res.ConnectionInfo.SendWindow = sendWindow
+	res.ConnectionInfo.ReceiveWindow = receiveWindow
+	res.ConnectionInfo.ReceiveFlowControl = receiveFlowControl
+}
+
+func (res *monitoringCommandResult) AddOrigin(origin string) {
+	res.ConnectionInfo.OriginSet = append(res.ConnectionInfo.OriginSet, origin)
+	sort.Strings(res.ConnectionInfo.OriginSet)
+}
+
+func (res *monitoringCommandResult) AddAlternativeService(origin string, value string) {
+	res.ConnectionInfo.AlternativeServices = append(res.ConnectionInfo.AlternativeServices, AlternativeService{
+		Origin: origin,
+		Value:  value,
+	})
+	sort.Sort(sortableAlternativeServiceSlice(res.ConnectionInfo.AlternativeServices))
+}
+
+func (res *monitoringCommandResult) AddStreamInfo(streamID uint32, httpMethod string, path string, state streamstate.StreamState, isCachedPushPromise bool, sendWindow int64, receiveWindow int64) {
 	res.StreamInfo = append(res.StreamInfo, StreamInfo{
 		StreamId:            streamID,
 		HttpMethod:          httpMethod,
 		Path:                path,
 		State:               state,
 		IsCachedPushPromise: isCachedPushPromise,
+		SendWindow:          sendWindow,
+		ReceiveWindow:       receiveWindow,
 	})
 	sort.Sort(res.StreamInfo)
 }
 
+func (res *monitoringCommandResult) AddQueuedRequest(httpMethod string, path string) {
+	res.QueuedRequests = append(res.QueuedRequests, QueuedRequest{
+		HttpMethod: httpMethod,
+		Path:       path,
+	})
+}
+
 func (s sortableStreamInfoSlice) Len() int {
 	return len(s)
 }
@@ -63,6 +133,20 @@ func (s sortableStreamInfoSlice) Swap(i, j int) {
 	s[i], s[j] = s[j], s[i]
 }
 
+type sortableAlternativeServiceSlice []AlternativeService
+
+func (s sortableAlternativeServiceSlice) Len() int {
+	return len(s)
+}
+
+func (s sortableAlternativeServiceSlice) Less(i, j int) bool {
+	return s[i].Origin < s[j].Origin
+}
+
+func (s sortableAlternativeServiceSlice) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
 func (cmd *MonitoringCommand) CompleteWithError(err error) {
 	cmd.callback.CompleteWithError(err)
 }
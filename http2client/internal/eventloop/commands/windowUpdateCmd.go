@@ -0,0 +1,33 @@
+package commands
+
+import (
+	"github.com/fstab/h2c/http2client/internal/util"
+)
+
+// WindowUpdateCommand sends a WINDOW_UPDATE frame. The increment is not validated,
+// so that the server's handling of invalid increments can be tested.
+type WindowUpdateCommand struct {
+	StreamId  uint32 // 0 means the connection's window.
+	Increment uint32
+	callback  *util.AsyncTask
+}
+
+func NewWindowUpdateCommand(streamId uint32, increment uint32) *WindowUpdateCommand {
+	return &WindowUpdateCommand{
+		StreamId:  streamId,
+		Increment: increment,
+		callback:  util.NewAsyncTask(),
+	}
+}
+
+func (cmd *WindowUpdateCommand) CompleteWithError(err error) {
+	cmd.callback.CompleteWithError(err)
+}
+
+func (cmd *WindowUpdateCommand) CompleteSuccessfully() {
+	cmd.callback.CompleteSuccessfully()
+}
+
+func (cmd *WindowUpdateCommand) AwaitCompletion(timeoutInSeconds int) error {
+	return cmd.callback.WaitForCompletion(timeoutInSeconds)
+}
@@ -5,18 +5,28 @@ import (
 	"github.com/fstab/h2c/http2client/frames"
 	"github.com/fstab/h2c/http2client/internal/connection"
 	"github.com/fstab/h2c/http2client/internal/eventloop/commands"
+	"github.com/fstab/h2c/http2client/internal/util"
 	"os"
+	"time"
 )
 
+// Interval for checking timeouts, like a server that does not acknowledge SETTINGS.
+const TIMEOUT_CHECK_INTERVAL = 1 * time.Second
+
 type Loop struct {
-	HttpCommands       chan (*commands.HttpCommand)
-	MonitoringCommands chan (*commands.MonitoringCommand)
-	PingCommands       chan (*commands.PingCommand)
-	IncomingFrames     chan (frames.Frame)
-	Shutdown           chan (bool)
-	Host               string
-	Port               int
-	terminated         bool
+	HttpCommands         chan (*commands.HttpCommand)
+	MonitoringCommands   chan (*commands.MonitoringCommand)
+	PingCommands         chan (*commands.PingCommand)
+	WindowUpdateCommands chan (*commands.WindowUpdateCommand)
+	IncomingFrames       chan (frames.Frame)
+	FrameErrors          chan (*frames.FrameError)
+	ReadErrors           chan (error)
+	Shutdown             chan (*util.AsyncTask) // Completed when the GOAWAY frame is sent and the connection is closed.
+	Scheme               string                 // "https" or "http"
+	Host                 string
+	Port                 int
+	done                 chan struct{} // Closed when the loop terminates.
+	closeReason          error         // Set before done is closed.
 }
 
 // Start starts the event loop managing the HTTP/2 communication with a server.
@@ -34,62 +44,94 @@ type Loop struct {
 // The implementation in github.com/fstab/h2c/http2client/connection does not need
 // to care about thread safety.
 //
-// There are two sources of events:
+// There are three sources of events:
 //
 // 1. Command line: A user types a comand in order to send a GET, POST, ... request.
 // 2. Network Socket: Frames received from the server.
-func Start(host string, port int, incomingFrameFilters []func(frames.Frame) frames.Frame, outgoingFrameFilters []func(frames.Frame) frames.Frame) (*Loop, error) {
+// 3. Timer: Timeouts are checked every TIMEOUT_CHECK_INTERVAL.
+func Start(scheme string, host string, port int, options *connection.Options, incomingFrameFilters []func(frames.Frame) frames.Frame, outgoingFrameFilters []func(frames.Frame) frames.Frame) (*Loop, error) {
 	l := &Loop{
-		HttpCommands:       make(chan (*commands.HttpCommand)),
-		MonitoringCommands: make(chan (*commands.MonitoringCommand)),
-		PingCommands:       make(chan (*commands.PingCommand)),
-		IncomingFrames:     make(chan (frames.Frame)),
-		Shutdown:           make(chan (bool)),
-		Host:               host,
-		Port:               port,
-		terminated:         false,
+		HttpCommands:         make(chan (*commands.HttpCommand)),
+		MonitoringCommands:   make(chan (*commands.MonitoringCommand)),
+		PingCommands:         make(chan (*commands.PingCommand)),
+		WindowUpdateCommands: make(chan (*commands.WindowUpdateCommand)),
+		IncomingFrames:       make(chan (frames.Frame)),
+		FrameErrors:          make(chan (*frames.FrameError)),
+		ReadErrors:           make(chan (error)),
+		Shutdown:             make(chan (*util.AsyncTask)),
+		Scheme:               scheme,
+		Host:                 host,
+		Port:                 port,
+		done:                 make(chan struct{}),
 	}
-	conn, err := connection.Start(host, port, incomingFrameFilters, outgoingFrameFilters)
-	stopFrameReader := false
+	conn, err := connection.Start(scheme, host, port, options, incomingFrameFilters, outgoingFrameFilters)
 	if err != nil {
 		return nil, err
 	}
 	// Start event loop
 	go func() {
+		ticker := time.NewTicker(TIMEOUT_CHECK_INTERVAL)
+		defer ticker.Stop()
 		for {
 			select {
 			case frame := <-l.IncomingFrames:
 				conn.HandleIncomingFrame(frame)
+			case err := <-l.FrameErrors:
+				conn.HandleFrameError(err)
 			case cmd := <-l.HttpCommands:
 				conn.ExecuteHttpCommand(cmd)
 			case cmd := <-l.PingCommands:
 				conn.ExecutePingCommand(cmd)
 			case cmd := <-l.MonitoringCommands:
 				conn.ExecuteMonitoringCommand(cmd)
-			case <-l.Shutdown:
+			case cmd := <-l.WindowUpdateCommands:
+				conn.ExecuteWindowUpdateCommand(cmd)
+			case err := <-l.ReadErrors:
+				conn.HandleReadError(err)
+			case task := <-l.Shutdown:
 				conn.Shutdown()
+				task.CompleteSuccessfully()
+			case now := <-ticker.C:
+				conn.CheckTimeouts(now)
 			}
 			if conn.IsShutdown() {
-				stopFrameReader = true // Would this change be visible in the other go function?
-				l.terminated = true
+				l.closeReason = conn.CloseReason()
+				close(l.done)
 				return
 			}
 		}
 	}()
-	// Read frames from network socket and provide them to the IncomingFrames channel
+	// Read frames from network socket and provide them to the IncomingFrames channel.
+	// The connection's state must not be accessed here, because this is not the event loop's goroutine.
 	go func() {
 		for {
 			frame, err := conn.ReadNextFrame()
-			if stopFrameReader {
-				return
+			select {
+			case <-l.done:
+				return // The connection was closed by the event loop.
+			default:
 			}
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error while reading next frame: %v\n", err.Error()) // TODO: Error handling
-				conn.Shutdown()
-				l.terminated = true
+			if frameErr, isFrameErr := err.(*frames.FrameError); isFrameErr {
+				// The frame was read completely but is invalid. The connection decides how to respond.
+				select {
+				case l.FrameErrors <- frameErr:
+				case <-l.done:
+					return
+				}
+			} else if err != nil {
+				// The connection is closed in the event loop, so that pending requests fail.
+				select {
+				case l.ReadErrors <- err:
+					fmt.Fprintf(os.Stderr, "Error while reading next frame: %v\n", err.Error())
+				case <-l.done:
+				}
 				return
 			} else {
-				l.IncomingFrames <- frame
+				select {
+				case l.IncomingFrames <- frame:
+				case <-l.done:
+					return
+				}
 			}
 		}
 	}()
@@ -97,5 +139,24 @@ func Start(host string, port int, incomingFrameFilters []func(frames.Frame) fram
 }
 
 func (l *Loop) IsTerminated() bool {
-	return l.terminated
+	select {
+	case <-l.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Done is closed when the loop terminates. Commands sent after that are not received anymore.
+func (l *Loop) Done() <-chan struct{} {
+	return l.done
+}
+
+// CloseReason tells why the connection was closed, like a GOAWAY frame received from the server.
+// It is nil as long as the loop is not terminated.
+func (l *Loop) CloseReason() error {
+	if !l.IsTerminated() {
+		return nil
+	}
+	return l.closeReason
 }
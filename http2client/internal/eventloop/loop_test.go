@@ -0,0 +1,219 @@
+package eventloop
+
+import (
+	"github.com/fstab/h2c/http2client/frames"
+	"github.com/fstab/h2c/http2client/internal/connection"
+	"github.com/fstab/h2c/http2client/internal/eventloop/commands"
+	"github.com/fstab/h2c/http2client/internal/util"
+	"golang.org/x/net/http2/hpack"
+	"io"
+	"net"
+	neturl "net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testServer is a cleartext HTTP/2 server controlled by the test, so that it can misbehave in any way.
+type testServer struct {
+	listener net.Listener
+	conn     net.Conn
+	reader   *frames.Reader
+	writer   *frames.Writer
+	t        *testing.T
+}
+
+func startTestServer(t *testing.T, options *connection.Options) (*testServer, *Loop) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &testServer{listener: listener, t: t}
+	accepted := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			server.conn = conn
+			_, err = io.ReadFull(conn, make([]byte, len(connection.CLIENT_PREFACE)))
+		}
+		accepted <- err
+	}()
+	port := listener.Addr().(*net.TCPAddr).Port
+	loop, err := Start("http", "127.0.0.1", port, options, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = <-accepted; err != nil {
+		t.Fatal(err)
+	}
+	server.reader = frames.NewReader(server.conn, frames.NewDecodingContext())
+	server.reader.ReassembleHeaderBlocks(true)
+	server.writer = frames.NewWriter(server.conn, frames.NewEncodingContext())
+	server.write(frames.NewSettingsFrame(0, false))
+	return server, loop
+}
+
+func (s *testServer) close() {
+	s.conn.Close()
+	s.listener.Close()
+}
+
+func (s *testServer) write(frame frames.Frame) {
+	if err := s.writer.WriteFrame(frame); err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+// expect reads frames from the client until a frame of the given type is received. Other frames are skipped.
+func (s *testServer) expect(frameType frames.Type) frames.Frame {
+	s.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		frame, err := s.reader.ReadFrame()
+		if err != nil {
+			s.t.Fatalf("Expected %v frame, but got error: %v", frameType, err)
+		}
+		if frame.Type() == frameType {
+			return frame
+		}
+	}
+}
+
+func sendGet(t *testing.T, loop *Loop, path string) *commands.HttpCommand {
+	url, err := neturl.Parse("http://" + loop.Host + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := commands.NewHttpCommand("GET", url)
+	loop.HttpCommands <- cmd
+	return cmd
+}
+
+func awaitTermination(t *testing.T, loop *Loop) {
+	for i := 0; i < 50 && !loop.IsTerminated(); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if !loop.IsTerminated() {
+		t.Fatal("Expected the event loop to terminate.")
+	}
+}
+
+func TestGoAwayReceived(t *testing.T) {
+	server, loop := startTestServer(t, &connection.Options{})
+	defer server.close()
+	cmd1 := sendGet(t, loop, "/1")
+	server.expect(frames.HEADERS_TYPE)
+	cmd3 := sendGet(t, loop, "/3")
+	server.expect(frames.HEADERS_TYPE)
+	goAway := frames.NewGoAwayFrame(0, 1, frames.NO_ERROR)
+	goAway.AdditionalDebugData = []byte("maintenance")
+	server.write(goAway)
+	err := cmd3.AwaitCompletion(5)
+	if _, isNotProcessed := err.(*commands.NotProcessedError); !isNotProcessed {
+		t.Fatalf("Expected stream 3 to fail with NotProcessedError, but got %v.", err)
+	}
+	// Stream 1 was processed by the server, so it can still complete.
+	cmd5 := sendGet(t, loop, "/5")
+	server.write(frames.NewHeadersFrame(1, []hpack.HeaderField{hpack.HeaderField{Name: ":status", Value: "200"}}))
+	if err = cmd1.AwaitCompletion(5); err != nil {
+		t.Fatalf("Expected stream 1 to complete, but got %v.", err)
+	}
+	if _, isNotProcessed := cmd5.AwaitCompletion(5).(*commands.NotProcessedError); !isNotProcessed {
+		t.Error("Expected requests after GOAWAY to fail with NotProcessedError.")
+	}
+	awaitTermination(t, loop)
+	if loop.CloseReason() == nil || !strings.Contains(loop.CloseReason().Error(), "maintenance") {
+		t.Errorf("Expected close reason with GOAWAY debug data, but got %v.", loop.CloseReason())
+	}
+}
+
+func TestShutdownSendsGoAway(t *testing.T) {
+	server, loop := startTestServer(t, &connection.Options{})
+	defer server.close()
+	cmd := sendGet(t, loop, "/")
+	server.expect(frames.HEADERS_TYPE)
+	task := util.NewAsyncTask()
+	loop.Shutdown <- task
+	if err := task.WaitForCompletion(5); err != nil {
+		t.Fatal(err)
+	}
+	goAway := server.expect(frames.GOAWAY_TYPE).(*frames.GoAwayFrame)
+	if goAway.ErrorCode != frames.NO_ERROR || goAway.LastStreamId != 0 {
+		t.Errorf("Expected GOAWAY with NO_ERROR and last stream id 0, but got %v and %v.", goAway.ErrorCode, goAway.LastStreamId)
+	}
+	if err := cmd.AwaitCompletion(5); err == nil {
+		t.Error("Expected the pending request to fail when the connection is closed.")
+	}
+}
+
+func TestServerClosesConnection(t *testing.T) {
+	server, loop := startTestServer(t, &connection.Options{})
+	cmd := sendGet(t, loop, "/")
+	server.expect(frames.HEADERS_TYPE)
+	server.close()
+	if err := cmd.AwaitCompletion(5); err == nil || !strings.Contains(err.Error(), "Connection closed") {
+		t.Errorf("Expected the pending request to fail because the connection was closed, but got %v.", err)
+	}
+	awaitTermination(t, loop)
+}
+
+func TestConnectionError(t *testing.T) {
+	server, loop := startTestServer(t, &connection.Options{})
+	defer server.close()
+	cmd := sendGet(t, loop, "/")
+	server.expect(frames.HEADERS_TYPE)
+	server.write(frames.NewPushPromiseFrame(1, 2, []hpack.HeaderField{
+		hpack.HeaderField{Name: ":method", Value: "GET"},
+		hpack.HeaderField{Name: ":scheme", Value: "http"},
+		hpack.HeaderField{Name: ":authority", Value: "127.0.0.1"},
+		hpack.HeaderField{Name: ":path", Value: "/pushed"},
+	}))
+	server.write(frames.NewRstStreamFrame(7, frames.CANCEL)) // RST_STREAM for an idle stream is a connection error.
+	goAway := server.expect(frames.GOAWAY_TYPE).(*frames.GoAwayFrame)
+	if goAway.ErrorCode != frames.PROTOCOL_ERROR || goAway.LastStreamId != 2 {
+		t.Errorf("Expected GOAWAY with PROTOCOL_ERROR and last stream id 2, but got %v and %v.", goAway.ErrorCode, goAway.LastStreamId)
+	}
+	if !strings.Contains(string(goAway.AdditionalDebugData), "IDLE") {
+		t.Errorf("Expected the error message as debug data, but got %q.", goAway.AdditionalDebugData)
+	}
+	err := cmd.AwaitCompletion(5)
+	if connErr, isConnErr := err.(*commands.ConnectionError); !isConnErr || connErr.ErrorCode != frames.PROTOCOL_ERROR {
+		t.Errorf("Expected the pending request to fail with ConnectionError, but got %v.", err)
+	}
+	awaitTermination(t, loop)
+	if _, isConnErr := loop.CloseReason().(*commands.ConnectionError); !isConnErr {
+		t.Errorf("Expected ConnectionError as close reason, but got %v.", loop.CloseReason())
+	}
+}
+
+func TestInvalidFrameIsConnectionError(t *testing.T) {
+	server, loop := startTestServer(t, &connection.Options{})
+	defer server.close()
+	cmd := sendGet(t, loop, "/")
+	server.expect(frames.HEADERS_TYPE)
+	// WINDOW_UPDATE on stream 0 with increment 0. The frame cannot be created with frames.NewWindowUpdateFrame.
+	_, err := server.conn.Write([]byte{0, 0, 4, byte(frames.WINDOW_UPDATE_TYPE), 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	goAway := server.expect(frames.GOAWAY_TYPE).(*frames.GoAwayFrame)
+	if goAway.ErrorCode != frames.PROTOCOL_ERROR || goAway.LastStreamId != 0 {
+		t.Errorf("Expected GOAWAY with PROTOCOL_ERROR and last stream id 0, but got %v and %v.", goAway.ErrorCode, goAway.LastStreamId)
+	}
+	if _, isConnErr := cmd.AwaitCompletion(5).(*commands.ConnectionError); !isConnErr {
+		t.Error("Expected the pending request to fail with ConnectionError.")
+	}
+	awaitTermination(t, loop)
+}
+
+func TestReceiveWindowSize(t *testing.T) {
+	server, _ := startTestServer(t, &connection.Options{ReceiveWindowSize: 1 << 20})
+	defer server.close()
+	settings := server.expect(frames.SETTINGS_TYPE).(*frames.SettingsFrame)
+	if frames.SETTINGS_INITIAL_WINDOW_SIZE.Get(settings) != 1<<20 {
+		t.Errorf("Expected %v %v, but got %v.", frames.SETTINGS_INITIAL_WINDOW_SIZE, 1<<20, frames.SETTINGS_INITIAL_WINDOW_SIZE.Get(settings))
+	}
+	windowUpdate := server.expect(frames.WINDOW_UPDATE_TYPE).(*frames.WindowUpdateFrame)
+	if windowUpdate.StreamId != 0 || windowUpdate.WindowSizeIncrement != 1<<20-65535 {
+		t.Errorf("Expected WINDOW_UPDATE for the connection with increment %v, but got %v for stream %v.", 1<<20-65535, windowUpdate.WindowSizeIncrement, windowUpdate.StreamId)
+	}
+}
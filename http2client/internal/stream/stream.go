@@ -3,6 +3,7 @@ package stream
 import (
 	"bytes"
 	"fmt"
+	"github.com/fstab/h2c/http2client/flowcontrol"
 	"github.com/fstab/h2c/http2client/frames"
 	"github.com/fstab/h2c/http2client/internal/eventloop/commands"
 	"github.com/fstab/h2c/http2client/internal/streamstate"
@@ -35,14 +36,35 @@ type Stream interface {
 	ReceiveFrame(frame frames.Frame)
 	// Send RST_STREAM
 	CloseWithError(errorCode frames.ErrorCode, msg string)
-	// Called by the connection if a WINDOW_UPDATE for the connection is received.
-	ProcessPendingDataFrames()
+	// Close the stream without sending RST_STREAM, because the connection is closed,
+	// or because the server will not process the stream. The command completes with err.
+	Abort(err error)
+	// Called by the connection's send scheduler. Sends the next pending DATA frame, or the part of it that fits into
+	// the flow-control windows and the server's SETTINGS_MAX_FRAME_SIZE. Returns false if nothing could be sent.
+	SendNextPendingDataFrame() bool
+	HasPendingDataFrames() bool
+	// Called by the connection if the server changes SETTINGS_INITIAL_WINDOW_SIZE, see RFC 7540 section 6.9.2.
+	// The window may become negative. An error is returned if the window exceeds flowcontrol.MAX_WINDOW_SIZE.
+	AdjustSendFlowControlWindow(delta int64) error
+	// Called by the connection if the client changes SETTINGS_INITIAL_WINDOW_SIZE.
+	AdjustReceiveFlowControlWindow(delta int64)
+	// Called by the connection if a WINDOW_UPDATE frame was sent with 'h2c window update'.
+	IncreaseReceiveFlowControlWindow(increment int64)
+	// Remaining send and receive windows.
+	FlowControlWindows() (int64, int64)
 }
 
 type FlowControlledFrameWriter interface {
 	Write(frame frames.Frame)
-	RemainingSendFlowControlWindowIsEnough(nBytesToWrite int64) bool
+	// Called by the stream when it has pending DATA frames. The writer calls SendNextPendingDataFrame() when the
+	// flow-control windows allow it.
+	SchedulePendingDataFrames(s Stream)
+	RemainingSendFlowControlWindow() int64
 	DecreaseSendFlowControlWindow(nBytesToWrite int64)
+	MaxFrameSize() uint32
+	// Called when the stream enters the CLOSED state, so that the connection can remove it and start queued requests.
+	StreamClosed(s Stream)
+	ReceiveFlowControlPolicy() flowcontrol.Policy
 }
 
 type streamError struct {
@@ -64,7 +86,7 @@ type stream struct {
 	requestHeaders             []hpack.HeaderField
 	responseHeaders            []hpack.HeaderField
 	responseBody               bytes.Buffer
-	err                        *streamError // RST_STREAM sent or received.
+	err                        error // RST_STREAM sent or received, or stream aborted.
 	cmd                        *commands.HttpCommand
 	initialSendWindowSize      int64
 	remainingSendWindowSize    int64
@@ -77,20 +99,29 @@ type stream struct {
 
 func New(streamId uint32, cmd *commands.HttpCommand, initialSendWindowSize uint32, initialReceiveWindowSize uint32, out FlowControlledFrameWriter) *stream {
 	return &stream{
-		state:           streamstate.IDLE,
-		requestHeaders:  make([]hpack.HeaderField, 0),
-		responseHeaders: make([]hpack.HeaderField, 0),
-		streamId:        streamId,
-		cmd:             cmd,
+		state:                      streamstate.IDLE,
+		requestHeaders:             make([]hpack.HeaderField, 0),
+		responseHeaders:            make([]hpack.HeaderField, 0),
+		streamId:                   streamId,
+		cmd:                        cmd,
 		initialSendWindowSize:      int64(initialSendWindowSize),
 		remainingSendWindowSize:    int64(initialSendWindowSize),
 		initialReceiveWindowSize:   int64(initialReceiveWindowSize),
 		remainingReceiveWindowSize: int64(initialReceiveWindowSize),
 		pendingDataFrameWrites:     make([]*frames.DataFrame, 0),
-		out: out,
+		out:                        out,
 	}
 }
 
+// NewUpgradeStream creates stream 1 for a connection that was upgraded from HTTP/1.1.
+// The response to the upgrade request is received on that stream.
+func NewUpgradeStream(requestHeaders []hpack.HeaderField, initialSendWindowSize uint32, initialReceiveWindowSize uint32, out FlowControlledFrameWriter) *stream {
+	result := New(1, nil, initialSendWindowSize, initialReceiveWindowSize, out)
+	result.addRequestHeaders(requestHeaders...)
+	streamstate.HandleUpgradeRequest(result)
+	return result
+}
+
 func (s *stream) ReceiveFrame(frame frames.Frame) {
 	wasClosedBefore := s.state == streamstate.CLOSED
 	err := streamstate.HandleIncomingFrame(s, frame)
@@ -110,13 +141,16 @@ func (s *stream) ReceiveFrame(frame frames.Frame) {
 	case *frames.PushPromiseFrame:
 		s.receivePushPromiseFrame(frame)
 	case *frames.WindowUpdateFrame:
-		s.receiveWindowUpdateFrame(frame)
+		if err := s.receiveWindowUpdateFrame(frame); err != nil {
+			s.CloseWithError(frames.FLOW_CONTROL_ERROR, err.Error())
+			return
+		}
 	default:
 		// TODO: error handling
 		fmt.Fprintf(os.Stderr, "Received unknown frame type %v\n", frame.Type())
 	}
 	if s.state == streamstate.CLOSED && !wasClosedBefore {
-		s.finalizeCommand()
+		s.closed()
 	}
 }
 
@@ -125,12 +159,10 @@ func (s *stream) receiveDataFrame(frame *frames.DataFrame) {
 	s.appendResponseBody(frame.Data)
 }
 
+// CONTINUATION frames are merged into the HEADERS frame by the connection,
+// so the frame always contains the complete header block.
 func (s *stream) receiveHeadersFrame(frame *frames.HeadersFrame) {
-	if !frame.EndHeaders {
-		s.CloseWithError(frames.REFUSED_STREAM, fmt.Sprintf("Unable to process %v without the END_HEADERS flag, because CONTINUATIONs are not implemented yet.", frame.Type()))
-	} else {
-		s.addResponseHeaders(frame.Headers...)
-	}
+	s.addResponseHeaders(frame.Headers...)
 }
 
 func (s *stream) receiveRstStreamFrame(frame *frames.RstStreamFrame) {
@@ -141,12 +173,10 @@ func (s *stream) receiveRstStreamFrame(frame *frames.RstStreamFrame) {
 	}
 }
 
+// CONTINUATION frames are merged into the PUSH_PROMISE frame by the connection,
+// so the frame always contains the complete header block.
 func (s *stream) receivePushPromiseFrame(frame *frames.PushPromiseFrame) {
-	if !frame.EndHeaders {
-		s.CloseWithError(frames.REFUSED_STREAM, fmt.Sprintf("%v with multiple header frames not supported.", frame.Type()))
-	} else {
-		s.addRequestHeaders(frame.Headers...)
-	}
+	s.addRequestHeaders(frame.Headers...)
 }
 
 func (s *stream) notImplementedYet(frame frames.Frame) {
@@ -159,15 +189,27 @@ func (s *stream) CloseWithError(errorCode frames.ErrorCode, msg string) {
 	}
 	rstStream := frames.NewRstStreamFrame(s.streamId, errorCode)
 	s.err = newStreamError("%v", msg)
+	s.pendingDataFrameWrites = make([]*frames.DataFrame, 0)
 	s.SendFrame(rstStream)
 }
 
+func (s *stream) Abort(err error) {
+	if s.state == streamstate.CLOSED {
+		return
+	}
+	s.err = err
+	s.pendingDataFrameWrites = make([]*frames.DataFrame, 0)
+	s.SetState(streamstate.CLOSED)
+	s.closed()
+}
+
 func (s *stream) SendFrame(frame frames.Frame) {
 	wasClosedBefore := s.state == streamstate.CLOSED
 	switch frame := frame.(type) {
 	case *frames.DataFrame:
-		firstInQueue := len(s.pendingDataFrameWrites) == 0
-		s.sendDataFrame(frame, firstInQueue)
+		// Sent by the connection's send scheduler, so that DATA frames of all streams share the connection's window.
+		s.pendingDataFrameWrites = append(s.pendingDataFrameWrites, frame)
+		s.out.SchedulePendingDataFrames(s)
 	case *frames.HeadersFrame:
 		s.addRequestHeaders(frame.Headers...)
 		streamstate.HandleOutgoingFrame(s, frame)
@@ -177,26 +219,46 @@ func (s *stream) SendFrame(frame frames.Frame) {
 		s.out.Write(frame)
 	}
 	if s.state == streamstate.CLOSED && !wasClosedBefore {
-		s.finalizeCommand()
+		s.closed()
 	}
 }
 
-// The frame will only be sent immediately if firstInQueue is true.
-// If the frame is not firstInQueue, it will be postponed so that the order of outgoing DATA frames is preserved.
-func (s *stream) sendDataFrame(frame *frames.DataFrame, firstInQueue bool) {
-	size := int64(len(frame.Data))
-	if firstInQueue && s.RemainingSendFlowControlWindowIsEnough(size) {
-		s.DecreaseSendFlowControlWindow(size)
-		streamstate.HandleOutgoingFrame(s, frame)
-		s.out.Write(frame)
-	} else {
-		s.scheduleDataFrameWrite(frame)
+func (s *stream) SendNextPendingDataFrame() bool {
+	if len(s.pendingDataFrameWrites) == 0 {
+		return false
+	}
+	frame := s.pendingDataFrameWrites[0]
+	available := min(s.remainingSendWindowSize, s.out.RemainingSendFlowControlWindow(), int64(s.out.MaxFrameSize()))
+	if int64(frame.PayloadLength()) <= available {
+		s.pendingDataFrameWrites = s.pendingDataFrameWrites[1:]
+		s.sendDataFrame(frame)
+		return true
+	}
+	// Split the frame. The Pad Length field and the padding are repeated in each part.
+	n := available - int64(frame.PayloadLength()-len(frame.Data))
+	if n <= 0 {
+		return false
 	}
+	part := frames.NewDataFrame(s.streamId, frame.Data[:n], false)
+	part.Padded = frame.Padded
+	part.PadLength = frame.PadLength
+	frame.Data = frame.Data[n:]
+	s.sendDataFrame(part)
+	return true
 }
 
-func (s *stream) RemainingSendFlowControlWindowIsEnough(nBytesToWrite int64) bool {
-	fmt.Fprintf(os.Stderr, "Stream: RemainingSendFlowControlWindowIsEnough(%v): %v > %v = %v\n", nBytesToWrite, s.remainingSendWindowSize, nBytesToWrite, s.remainingSendWindowSize > nBytesToWrite)
-	return s.remainingSendWindowSize > nBytesToWrite && s.out.RemainingSendFlowControlWindowIsEnough(nBytesToWrite)
+func (s *stream) HasPendingDataFrames() bool {
+	return len(s.pendingDataFrameWrites) > 0
+}
+
+func (s *stream) sendDataFrame(frame *frames.DataFrame) {
+	wasClosedBefore := s.state == streamstate.CLOSED
+	s.DecreaseSendFlowControlWindow(int64(frame.PayloadLength()))
+	streamstate.HandleOutgoingFrame(s, frame)
+	s.out.Write(frame)
+	if s.state == streamstate.CLOSED && !wasClosedBefore {
+		s.closed()
+	}
 }
 
 func (s *stream) DecreaseSendFlowControlWindow(nBytesToWrite int64) {
@@ -204,38 +266,45 @@ func (s *stream) DecreaseSendFlowControlWindow(nBytesToWrite int64) {
 	s.remainingSendWindowSize -= nBytesToWrite
 }
 
-func (s *stream) receiveWindowUpdateFrame(frame *frames.WindowUpdateFrame) {
-	// TODO: stream error if increment is 0.
-	s.remainingSendWindowSize += int64(frame.WindowSizeIncrement)
-	s.ProcessPendingDataFrames()
+func (s *stream) AdjustSendFlowControlWindow(delta int64) error {
+	if s.remainingSendWindowSize+delta > flowcontrol.MAX_WINDOW_SIZE {
+		return fmt.Errorf("Changing %v to %v would increase the flow-control window of stream %v to %v.", frames.SETTINGS_INITIAL_WINDOW_SIZE, s.initialSendWindowSize+delta, s.streamId, s.remainingSendWindowSize+delta)
+	}
+	s.initialSendWindowSize += delta
+	s.remainingSendWindowSize += delta
+	return nil
 }
 
-// Just a quick implementation to make large downloads work.
-// Should be replaced with a more sophisticated flow control strategy
-// TODO: This is copy-and-paste from stream
-func (s *stream) flowControlForIncomingDataFrame(frame *frames.DataFrame) {
-	threshold := int64(2 << 13) // size of one frame
-	s.remainingReceiveWindowSize -= int64(len(frame.Data))
-	if s.remainingReceiveWindowSize < threshold {
-		diff := s.initialReceiveWindowSize - s.remainingReceiveWindowSize
-		s.remainingReceiveWindowSize += diff
-		s.SendFrame(frames.NewWindowUpdateFrame(s.streamId, uint32(diff)))
+func (s *stream) IncreaseReceiveFlowControlWindow(increment int64) {
+	s.remainingReceiveWindowSize += increment
+}
+
+func (s *stream) FlowControlWindows() (int64, int64) {
+	return s.remainingSendWindowSize, s.remainingReceiveWindowSize
+}
+
+// RFC 7540 section 6.9.1: A WINDOW_UPDATE that makes the window exceed 2^31-1 is a stream error of type FLOW_CONTROL_ERROR.
+func (s *stream) receiveWindowUpdateFrame(frame *frames.WindowUpdateFrame) error {
+	if s.remainingSendWindowSize+int64(frame.WindowSizeIncrement) > flowcontrol.MAX_WINDOW_SIZE {
+		return fmt.Errorf("%v with increment %v would increase the flow-control window of stream %v to %v.", frame.Type(), frame.WindowSizeIncrement, s.streamId, s.remainingSendWindowSize+int64(frame.WindowSizeIncrement))
 	}
+	s.remainingSendWindowSize += int64(frame.WindowSizeIncrement)
+	s.out.SchedulePendingDataFrames(s)
+	return nil
 }
 
-func (s *stream) ProcessPendingDataFrames() {
-	for len(s.pendingDataFrameWrites) > 0 {
-		nextFrame := s.pendingDataFrameWrites[0]
-		if !s.RemainingSendFlowControlWindowIsEnough(int64(len(nextFrame.Data))) {
-			break // must stop here, because data frames must be sent in the right order
-		}
-		s.pendingDataFrameWrites = s.pendingDataFrameWrites[1:] // TODO: Memory Leak ???
-		s.sendDataFrame(nextFrame, true)
+func (s *stream) flowControlForIncomingDataFrame(frame *frames.DataFrame) {
+	s.remainingReceiveWindowSize -= int64(frame.PayloadLength())
+	increment := s.out.ReceiveFlowControlPolicy().WindowUpdate(s.initialReceiveWindowSize, s.remainingReceiveWindowSize)
+	if increment > 0 {
+		s.remainingReceiveWindowSize += increment
+		s.SendFrame(frames.NewWindowUpdateFrame(s.streamId, uint32(increment)))
 	}
 }
 
-func (s *stream) scheduleDataFrameWrite(frame *frames.DataFrame) {
-	s.pendingDataFrameWrites = append(s.pendingDataFrameWrites, frame)
+func (s *stream) AdjustReceiveFlowControlWindow(delta int64) {
+	s.initialReceiveWindowSize += delta
+	s.remainingReceiveWindowSize += delta
 }
 
 func (s *stream) addRequestHeaders(headers ...hpack.HeaderField) {
@@ -254,6 +323,12 @@ func (s *stream) appendResponseBody(data []byte) {
 	s.responseBody.Write(data)
 }
 
+// closed is called once when the stream enters the CLOSED state.
+func (s *stream) closed() {
+	s.finalizeCommand()
+	s.out.StreamClosed(s)
+}
+
 func (s *stream) finalizeCommand() {
 	if s.cmd != nil {
 		if s.err != nil {
@@ -298,3 +373,13 @@ func (s *stream) AssociateWithCommand(cmd *commands.HttpCommand) error {
 	}
 	return nil
 }
+
+func min(numbers ...int64) int64 {
+	result := numbers[0]
+	for _, n := range numbers {
+		if n < result {
+			result = n
+		}
+	}
+	return result
+}
@@ -108,6 +108,13 @@ func HandleOutgoingFrame(stream stateful, frame frames.Frame) {
 	}
 }
 
+// HandleUpgradeRequest is called for stream 1 if the connection was upgraded from HTTP/1.1.
+// The upgrade request counts as a request sent with END_STREAM, see RFC 7540 section 3.2.
+func HandleUpgradeRequest(stream stateful) {
+	stream.GetState().MustBeIn(IDLE)
+	stream.SetState(HALF_CLOSED_LOCAL)
+}
+
 func newStreamStateError(format string, a ...interface{}) *StreamStateError {
 	return &StreamStateError{
 		frames.STREAM_CLOSED,
@@ -55,6 +55,20 @@ func TestPostRequest(t *testing.T) {
 	assertNil(t, err)
 }
 
+func TestUpgradeRequest(t *testing.T) {
+	stream := &mockStream{
+		state: IDLE,
+	}
+	HandleUpgradeRequest(stream) // HTTP/1.1 request with Upgrade: h2c
+	assertState(t, stream, HALF_CLOSED_LOCAL)
+	err := HandleIncomingFrame(stream, newHeadersFrame(false)) // 200 OK
+	assertState(t, stream, HALF_CLOSED_LOCAL)
+	assertNil(t, err)
+	err = HandleIncomingFrame(stream, newDataFrame(true)) // DATA
+	assertState(t, stream, CLOSED)
+	assertNil(t, err)
+}
+
 func TestPushPromise(t *testing.T) {
 	stream := &mockStream{
 		state: IDLE,
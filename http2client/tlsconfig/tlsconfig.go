@@ -0,0 +1,217 @@
+// Package tlsconfig creates the TLS configuration for connections to HTTP/2 servers.
+//
+// It is used by the http2client and by the wiretap, so that both verify server certificates the same way.
+package tlsconfig
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+const PIN_PREFIX = "sha256//"
+
+// Options control how the server certificate is verified, and which client certificate is presented to the server.
+// The zero value means the certificate is verified against the system's root CAs, and no client certificate is sent.
+type Options struct {
+	Insecure   bool     // Do not verify the server certificate.
+	CaCertFile string   // PEM file with trusted CA certificates. Empty means the system's root CAs are used.
+	Pins       []string // Public key pins like "sha256//<base64>". If not empty, the certificate chain must contain one of the keys.
+	ServerName string   // Server name for SNI and certificate verification. Empty means the host name is used.
+	CertFile   string   // PEM file with the client certificate chain. Empty means no client certificate is sent.
+	KeyFile    string   // PEM file with the unencrypted private key (PKCS#1, PKCS#8, or EC). Empty means the key is read from CertFile.
+	KeyLogFile string   // Append TLS secrets in NSS key log format, like SSLKEYLOGFILE in browsers. Empty means no key log.
+}
+
+// Open key log files by path. Connections using the same key log file share a single open file.
+var keyLogFiles = struct {
+	sync.Mutex
+	files map[string]*os.File
+}{files: make(map[string]*os.File)}
+
+// New creates a tls.Config for connecting to host.
+func New(host string, nextProtos []string, options *Options) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName: host,
+		NextProtos: nextProtos,
+	}
+	if options == nil {
+		return config, nil
+	}
+	if options.ServerName != "" {
+		config.ServerName = options.ServerName
+	}
+	config.InsecureSkipVerify = options.Insecure
+	if options.CaCertFile != "" {
+		pem, err := ioutil.ReadFile(options.CaCertFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read CA certificates: %v", err.Error())
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%v: No PEM encoded certificates found.", options.CaCertFile)
+		}
+	}
+	if len(options.Pins) > 0 {
+		pins := options.Pins
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			return verifyPins(state, pins)
+		}
+	}
+	clientCert, err := loadClientCert(options)
+	if err != nil {
+		return nil, err
+	}
+	// Unlike config.Certificates, the callback sends the certificate even if it does not match
+	// the CAs accepted by the server. This way, the server's error is reported instead of silently sending no certificate.
+	config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		return clientCert, nil
+	}
+	if options.KeyLogFile != "" {
+		config.KeyLogWriter, err = KeyLogWriter(options.KeyLogFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+// KeyLogWriter returns a writer for tls.Config.KeyLogWriter, appending to the file at path.
+// The file is created if it does not exist, and is kept open for subsequent connections.
+// Wireshark can use the file to decrypt captured TLS traffic.
+func KeyLogWriter(path string) (io.Writer, error) {
+	keyLogFiles.Lock()
+	defer keyLogFiles.Unlock()
+	if file, exists := keyLogFiles.files[path]; exists {
+		return file, nil
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open key log file: %v", err.Error())
+	}
+	keyLogFiles.files[path] = file
+	return file, nil
+}
+
+// loadClientCert returns an empty certificate if no CertFile is configured, which means no client certificate is sent.
+func loadClientCert(options *Options) (*tls.Certificate, error) {
+	if options.CertFile == "" {
+		if options.KeyFile != "" {
+			return nil, fmt.Errorf("%v: A private key requires a client certificate.", options.KeyFile)
+		}
+		return &tls.Certificate{}, nil
+	}
+	keyFile := options.KeyFile
+	if keyFile == "" {
+		keyFile = options.CertFile
+	}
+	cert, err := tls.LoadX509KeyPair(options.CertFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load client certificate %v with key %v: %v. The key must be a PEM encoded private key without passphrase.", options.CertFile, keyFile, err.Error())
+	}
+	return &cert, nil
+}
+
+// OnClientCertificateRequest makes config call callback when the server requests a client certificate during the handshake.
+func OnClientCertificateRequest(config *tls.Config, callback func()) {
+	getClientCertificate := config.GetClientCertificate
+	if getClientCertificate == nil {
+		getClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return &tls.Certificate{}, nil
+		}
+	}
+	config.GetClientCertificate = func(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		callback()
+		return getClientCertificate(info)
+	}
+}
+
+// ClientCertificateError returns a descriptive error if err is an alert sent by the server
+// because it rejected the client certificate, or because no client certificate was sent.
+// Otherwise, nil is returned. Some servers send a generic alert like "handshake failure",
+// so clientCertRequested should tell if the server requested a client certificate, see OnClientCertificateRequest().
+//
+// With TLS 1.3, the server verifies the client certificate after the client considers the handshake complete,
+// so the alert may also be returned by the first read from the connection.
+func ClientCertificateError(options *Options, err error, clientCertRequested bool) error {
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) || opErr.Op != "remote error" {
+		return nil
+	}
+	if !clientCertRequested && !strings.Contains(opErr.Err.Error(), "certificate") {
+		return nil
+	}
+	if options == nil || options.CertFile == "" {
+		return fmt.Errorf("Server requested a client certificate (%v). Use --cert and --key to provide one.", opErr.Err.Error())
+	}
+	return fmt.Errorf("Server rejected the client certificate %v (%v).", options.CertFile, opErr.Err.Error())
+}
+
+// ParsePins splits a list of public key pins separated by ';', like "sha256//<base64>;sha256//<base64>".
+func ParsePins(list string) ([]string, error) {
+	result := make([]string, 0)
+	for _, pin := range strings.Split(list, ";") {
+		pin = strings.TrimSpace(pin)
+		if !strings.HasPrefix(pin, PIN_PREFIX) {
+			return nil, fmt.Errorf("%v: invalid pin. Expected format is %v<base64>.", pin, PIN_PREFIX)
+		}
+		hash, err := base64.StdEncoding.DecodeString(pin[len(PIN_PREFIX):])
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("%v: invalid pin. Expected base64 encoded SHA-256 hash.", pin)
+		}
+		result = append(result, pin)
+	}
+	return result, nil
+}
+
+// Pin returns the public key pin of the certificate, which is the base64 encoded SHA-256 hash of the
+// Subject Public Key Info, as in 'openssl x509 -pubkey | openssl pkey -pubin -outform der | openssl dgst -sha256 -binary | base64'.
+func Pin(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return PIN_PREFIX + base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// If the chain was verified, one of the certificates in the verified chains must match.
+// Otherwise, the chain presented by the server cannot be trusted, and only the leaf certificate is checked.
+func verifyPins(state tls.ConnectionState, pins []string) error {
+	candidates := make([]*x509.Certificate, 0)
+	for _, chain := range state.VerifiedChains {
+		candidates = append(candidates, chain...)
+	}
+	if len(state.VerifiedChains) == 0 && len(state.PeerCertificates) > 0 {
+		candidates = append(candidates, state.PeerCertificates[0])
+	}
+	for _, cert := range candidates {
+		for _, pin := range pins {
+			if Pin(cert) == pin {
+				return nil
+			}
+		}
+	}
+	if len(state.PeerCertificates) == 0 {
+		return fmt.Errorf("Public key pinning failed: Server did not send a certificate.")
+	}
+	return fmt.Errorf("Public key pinning failed: Server certificate has public key %v.", Pin(state.PeerCertificates[0]))
+}
+
+// ChainSummary returns one line per certificate presented by the server, starting with the server's certificate.
+func ChainSummary(state tls.ConnectionState) []string {
+	chain := state.PeerCertificates
+	if len(state.VerifiedChains) > 0 {
+		chain = state.VerifiedChains[0]
+	}
+	result := make([]string, 0, len(chain))
+	for _, cert := range chain {
+		result = append(result, fmt.Sprintf("%v (issuer: %v, valid until %v, public key %v)", cert.Subject, cert.Issuer, cert.NotAfter.Format("2006-01-02"), Pin(cert)))
+	}
+	return result
+}
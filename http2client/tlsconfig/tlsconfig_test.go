@@ -0,0 +1,147 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newSelfSignedCert(t *testing.T) *x509.Certificate {
+	cert, _ := newSelfSignedCertAndKey(t)
+	return cert
+}
+
+func newSelfSignedCertAndKey(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func writePem(t *testing.T, dir string, name string, blockType string, der []byte) string {
+	path := filepath.Join(dir, name)
+	err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParsePins(t *testing.T) {
+	pins, err := ParsePins("sha256//47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=; sha256//LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=")
+	if err != nil || len(pins) != 2 {
+		t.Errorf("Expected 2 pins, but got %v (error: %v).", pins, err)
+	}
+	for _, invalid := range []string{"47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=", "sha256//abc", "sha1//47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="} {
+		_, err = ParsePins(invalid)
+		if err == nil {
+			t.Errorf("Expected error for pin %v.", invalid)
+		}
+	}
+}
+
+func TestPinning(t *testing.T) {
+	cert := newSelfSignedCert(t)
+	state := tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	config, err := New("localhost", nil, &Options{Insecure: true, Pins: []string{Pin(cert)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = config.VerifyConnection(state); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	config, err = New("localhost", nil, &Options{Insecure: true, Pins: []string{Pin(newSelfSignedCert(t))}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = config.VerifyConnection(state); err == nil {
+		t.Error("Expected pinning to fail for a different public key.")
+	}
+}
+
+func TestClientCertificate(t *testing.T) {
+	cert, key := newSelfSignedCertAndKey(t)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile := writePem(t, dir, "cert.pem", "CERTIFICATE", cert.Raw)
+	keyFile := writePem(t, dir, "key.pem", "PRIVATE KEY", pkcs8)
+	config, err := New("localhost", nil, &Options{CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCert, err := config.GetClientCertificate(&tls.CertificateRequestInfo{})
+	if err != nil || len(clientCert.Certificate) != 1 || !cert.Equal(mustParse(t, clientCert.Certificate[0])) {
+		t.Errorf("Expected the client certificate to be sent (error: %v).", err)
+	}
+	config, err = New("localhost", nil, &Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCert, err = config.GetClientCertificate(&tls.CertificateRequestInfo{})
+	if err != nil || len(clientCert.Certificate) != 0 {
+		t.Errorf("Expected no client certificate to be sent (error: %v).", err)
+	}
+	for _, options := range []*Options{
+		&Options{KeyFile: keyFile},
+		&Options{CertFile: certFile}, // The certificate file does not contain the key.
+		&Options{CertFile: keyFile, KeyFile: certFile},
+	} {
+		_, err = New("localhost", nil, options)
+		if err == nil {
+			t.Errorf("Expected error for cert %v and key %v.", options.CertFile, options.KeyFile)
+		}
+	}
+}
+
+func mustParse(t *testing.T, der []byte) *x509.Certificate {
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestKeyLogFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keylog.txt")
+	config1, err := New("localhost", nil, &Options{KeyLogFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config2, err := New("localhost", nil, &Options{KeyLogFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config1.KeyLogWriter == nil || config1.KeyLogWriter != config2.KeyLogWriter {
+		t.Error("Expected connections with the same key log file to share the writer.")
+	}
+	if _, err = New("localhost", nil, &Options{KeyLogFile: filepath.Join(path, "not-a-directory")}); err == nil {
+		t.Error("Expected error for invalid key log file.")
+	}
+}